@@ -4,11 +4,13 @@ import (
     "fmt"
     "monkey/token"
     "strings"
+    "unicode"
 )
 
 type Node interface {
     fmt.Stringer
     Literal() string
+    Pos() token.Position
 }
 
 type Statement interface {
@@ -25,6 +27,7 @@ type Expression interface {
 
 type Program struct {
     Statements []Statement
+    Comments   []token.Comment // every comment of the source in order, also attached to the tokens as trivia
 }
 
 func (program *Program) String() string {
@@ -46,6 +49,13 @@ func (program *Program) Literal() string {
     return ""
 }
 
+func (program *Program) Pos() token.Position {
+    if len(program.Statements) > 0 {
+        return program.Statements[0].Pos()
+    }
+    return token.Position{}
+}
+
 // ====================================================================================================
 
 // ========================================   LetStatement   ==========================================
@@ -74,6 +84,10 @@ func (letStmt LetStatement) Literal() string {
     return letStmt.Token.Literal
 }
 
+func (letStmt LetStatement) Pos() token.Position {
+    return letStmt.Token.Pos
+}
+
 func (letStmt LetStatement) statementNode() {
 
 }
@@ -99,11 +113,16 @@ func (retStmt *ReturnStatement) Literal() string {
     return retStmt.ReturnValue.Literal()
 }
 
+func (retStmt *ReturnStatement) Pos() token.Position {
+    return retStmt.Token.Pos
+}
+
 // =====================================================================================================
 
 type BlockStatement struct {
     Token      *token.Token
     Statements []Statement
+    Rbrace     *token.Token // the closing brace, nil if the block was not parsed from source
 }
 
 func (blockStmt *BlockStatement) String() string {
@@ -121,6 +140,10 @@ func (blockStmt *BlockStatement) Literal() string {
     return blockStmt.Token.Literal
 }
 
+func (blockStmt *BlockStatement) Pos() token.Position {
+    return blockStmt.Token.Pos
+}
+
 func (blockStmt *BlockStatement) statementNode() {
 
 }
@@ -140,6 +163,10 @@ func (exprStmt *ExpressionStatement) Literal() string {
     return exprStmt.Token.Literal
 }
 
+func (exprStmt *ExpressionStatement) Pos() token.Position {
+    return exprStmt.Token.Pos
+}
+
 func (exprStmt *ExpressionStatement) statementNode() {
 }
 
@@ -166,6 +193,10 @@ func (prefixExpr *PrefixExpression) Literal() string {
     return prefixExpr.Token.Literal
 }
 
+func (prefixExpr *PrefixExpression) Pos() token.Position {
+    return prefixExpr.Token.Pos
+}
+
 func (prefixExpr *PrefixExpression) expressionNode() {
 
 }
@@ -195,11 +226,49 @@ func (infixExpr *InfixExpression) Literal() string {
     return infixExpr.Token.Literal
 }
 
+func (infixExpr *InfixExpression) Pos() token.Position {
+    return infixExpr.Token.Pos
+}
+
 func (infixExpr *InfixExpression) expressionNode() {
 }
 
 // =====================================================================================================
 
+// ========================================   AssignExpression  ========================================
+
+// AssignExpression stores Value in Target, an identifier or an index expression. Operator is "=" or a
+// compound operator like "+=", which combines the current value of Target with Value first.
+type AssignExpression struct {
+    Token    *token.Token
+    Target   Expression
+    Operator string
+    Value    Expression
+}
+
+func (assignExpr *AssignExpression) String() string {
+    var builder strings.Builder
+    builder.WriteString("(")
+    builder.WriteString(assignExpr.Target.String())
+    builder.WriteString(assignExpr.Operator)
+    builder.WriteString(assignExpr.Value.String())
+    builder.WriteString(")")
+    return builder.String()
+}
+
+func (assignExpr *AssignExpression) Literal() string {
+    return assignExpr.Token.Literal
+}
+
+func (assignExpr *AssignExpression) Pos() token.Position {
+    return assignExpr.Token.Pos
+}
+
+func (assignExpr *AssignExpression) expressionNode() {
+}
+
+// =====================================================================================================
+
 // =========================================   IfExpression   ==========================================
 
 type IfExpression struct {
@@ -227,6 +296,10 @@ func (infixExpr *IfExpression) Literal() string {
     return infixExpr.Token.Literal
 }
 
+func (infixExpr *IfExpression) Pos() token.Position {
+    return infixExpr.Token.Pos
+}
+
 func (infixExpr *IfExpression) expressionNode() {
 }
 
@@ -259,6 +332,11 @@ func (callExpr *CallExpression) Literal() string {
     return callExpr.Token.Literal
 }
 
+// Pos returns the position of the callee rather than of the opening parenthesis.
+func (callExpr *CallExpression) Pos() token.Position {
+    return callExpr.Function.Pos()
+}
+
 func (callExpr *CallExpression) expressionNode() {
 }
 
@@ -283,6 +361,10 @@ func (id Identifier) Literal() string {
     return id.Token.Literal
 }
 
+func (id Identifier) Pos() token.Position {
+    return id.Token.Pos
+}
+
 // =====================================================================================================
 
 type Integer struct {
@@ -302,6 +384,164 @@ func (integer *Integer) Literal() string {
     return integer.Token.Literal
 }
 
+func (integer *Integer) Pos() token.Position {
+    return integer.Token.Pos
+}
+
+// FloatLiteral keeps the literal as written in its token, Value is the nearest float64.
+type FloatLiteral struct {
+    Token *token.Token
+    Value float64
+}
+
+func (float *FloatLiteral) expressionNode() {
+
+}
+
+func (float *FloatLiteral) String() string {
+    return float.Token.Literal
+}
+
+func (float *FloatLiteral) Literal() string {
+    return float.Token.Literal
+}
+
+func (float *FloatLiteral) Pos() token.Position {
+    return float.Token.Pos
+}
+
+type StringLiteral struct {
+    Token *token.Token
+    Value string
+}
+
+func (str *StringLiteral) expressionNode() {
+
+}
+
+func (str *StringLiteral) String() string {
+    return Quote(str.Value)
+}
+
+func (str *StringLiteral) Literal() string {
+    return str.Token.Literal
+}
+
+func (str *StringLiteral) Pos() token.Position {
+    return str.Token.Pos
+}
+
+// Quote returns value as a Monkey string literal, escaping it the way the lexer expects.
+func Quote(value string) string {
+    var builder strings.Builder
+    builder.WriteByte('"')
+    for _, r := range value {
+        switch r {
+        case '"':
+            builder.WriteString(`\"`)
+        case '\\':
+            builder.WriteString(`\\`)
+        case '\n':
+            builder.WriteString(`\n`)
+        case '\t':
+            builder.WriteString(`\t`)
+        default:
+            if unicode.IsPrint(r) {
+                builder.WriteRune(r)
+            } else {
+                builder.WriteString(fmt.Sprintf(`\u{%x}`, r))
+            }
+        }
+    }
+    builder.WriteByte('"')
+    return builder.String()
+}
+
+type ArrayLiteral struct {
+    Token    *token.Token
+    Elements []Expression
+}
+
+func (array *ArrayLiteral) expressionNode() {
+
+}
+
+func (array *ArrayLiteral) String() string {
+    var t []string
+    for _, element := range array.Elements {
+        t = append(t, element.String())
+    }
+    return "[" + strings.Join(t, ",") + "]"
+}
+
+func (array *ArrayLiteral) Literal() string {
+    return array.Token.Literal
+}
+
+func (array *ArrayLiteral) Pos() token.Position {
+    return array.Token.Pos
+}
+
+// =========================================   HashLiteral   ===========================================
+
+type HashPair struct {
+    Key   Expression
+    Value Expression
+}
+
+// HashLiteral keeps its pairs in source order.
+type HashLiteral struct {
+    Token *token.Token
+    Pairs []HashPair
+}
+
+func (hash *HashLiteral) expressionNode() {
+
+}
+
+func (hash *HashLiteral) String() string {
+    var t []string
+    for _, pair := range hash.Pairs {
+        t = append(t, pair.Key.String()+":"+pair.Value.String())
+    }
+    return "{" + strings.Join(t, ",") + "}"
+}
+
+func (hash *HashLiteral) Literal() string {
+    return hash.Token.Literal
+}
+
+func (hash *HashLiteral) Pos() token.Position {
+    return hash.Token.Pos
+}
+
+// =====================================================================================================
+
+// ========================================   IndexExpression  =========================================
+
+type IndexExpression struct {
+    Token *token.Token
+    Left  Expression
+    Index Expression
+}
+
+func (indexExpr *IndexExpression) String() string {
+    return "(" + indexExpr.Left.String() + "[" + indexExpr.Index.String() + "])"
+}
+
+func (indexExpr *IndexExpression) Literal() string {
+    return indexExpr.Token.Literal
+}
+
+func (indexExpr *IndexExpression) Pos() token.Position {
+    return indexExpr.Token.Pos
+}
+
+func (indexExpr *IndexExpression) expressionNode() {
+}
+
+// =====================================================================================================
+
 type Boolean struct {
     Token *token.Token
     Value bool
@@ -318,6 +558,10 @@ func (boolean *Boolean) Literal() string {
     return boolean.Token.Literal
 }
 
+func (boolean *Boolean) Pos() token.Position {
+    return boolean.Token.Pos
+}
+
 func (boolean *Boolean) expressionNode() {
 
 }
@@ -347,5 +591,38 @@ func (function *Function) Literal() string {
     return function.Token.Literal
 }
 
+func (function *Function) Pos() token.Position {
+    return function.Token.Pos
+}
+
 func (function *Function) expressionNode() {
 }
+
+// ==========================================  MacroLiteral  ===========================================
+
+type MacroLiteral struct {
+    Token  *token.Token
+    Params []*Identifier
+    Body   *BlockStatement
+}
+
+func (macro *MacroLiteral) String() string {
+    var t []string
+    for _, param := range macro.Params {
+        t = append(t, param.String())
+    }
+    return "macro(" + strings.Join(t, ",") + ") " + macro.Body.String()
+}
+
+func (macro *MacroLiteral) Literal() string {
+    return macro.Token.Literal
+}
+
+func (macro *MacroLiteral) Pos() token.Position {
+    return macro.Token.Pos
+}
+
+func (macro *MacroLiteral) expressionNode() {
+}
+
+// =====================================================================================================
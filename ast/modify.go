@@ -0,0 +1,238 @@
+package ast
+
+// ModifierFunc replaces a node. Returning the node itself keeps it unchanged.
+type ModifierFunc func(Node) Node
+
+// Modify walks the tree below node depth-first, replacing every node with the result of modifier.
+// Children are modified before their parent, nil children are left alone. A replacement that does
+// not fit its place, e.g. a statement returned for an expression, is ignored.
+func Modify(node Node, modifier ModifierFunc) Node {
+    switch node := node.(type) {
+    case *Program:
+        modifyStatements(node.Statements, modifier)
+    case *ExpressionStatement:
+        node.Expression = modifyExpression(node.Expression, modifier)
+    case *BlockStatement:
+        modifyStatements(node.Statements, modifier)
+    case *LetStatement:
+        node.Name = modifyIdentifier(node.Name, modifier)
+        node.Value = modifyExpression(node.Value, modifier)
+    case *ReturnStatement:
+        node.ReturnValue = modifyExpression(node.ReturnValue, modifier)
+    case *PrefixExpression:
+        node.Right = modifyExpression(node.Right, modifier)
+    case *InfixExpression:
+        node.Left = modifyExpression(node.Left, modifier)
+        node.Right = modifyExpression(node.Right, modifier)
+    case *AssignExpression:
+        node.Target = modifyExpression(node.Target, modifier)
+        node.Value = modifyExpression(node.Value, modifier)
+    case *IfExpression:
+        node.Condition = modifyExpression(node.Condition, modifier)
+        node.Consequence = modifyBlock(node.Consequence, modifier)
+        node.Alternative = modifyBlock(node.Alternative, modifier)
+    case *CallExpression:
+        node.Function = modifyExpression(node.Function, modifier)
+        modifyExpressions(node.Arguments, modifier)
+    case *IndexExpression:
+        node.Left = modifyExpression(node.Left, modifier)
+        node.Index = modifyExpression(node.Index, modifier)
+    case *Function:
+        modifyParams(node.Params, modifier)
+        node.Body = modifyBlock(node.Body, modifier)
+    case *MacroLiteral:
+        modifyParams(node.Params, modifier)
+        node.Body = modifyBlock(node.Body, modifier)
+    case *ArrayLiteral:
+        modifyExpressions(node.Elements, modifier)
+    case *HashLiteral:
+        for i, pair := range node.Pairs {
+            node.Pairs[i] = HashPair{Key: modifyExpression(pair.Key, modifier), Value: modifyExpression(pair.Value, modifier)}
+        }
+    }
+    return modifier(node)
+}
+
+func modifyExpression(expr Expression, modifier ModifierFunc) Expression {
+    if expr == nil {
+        return nil
+    }
+    if modified, ok := Modify(expr, modifier).(Expression); ok {
+        return modified
+    }
+    return expr
+}
+
+func modifyExpressions(exprs []Expression, modifier ModifierFunc) {
+    for i, expr := range exprs {
+        exprs[i] = modifyExpression(expr, modifier)
+    }
+}
+
+func modifyStatements(stmts []Statement, modifier ModifierFunc) {
+    for i, stmt := range stmts {
+        if stmt == nil {
+            continue
+        }
+        if modified, ok := Modify(stmt, modifier).(Statement); ok {
+            stmts[i] = modified
+        }
+    }
+}
+
+func modifyBlock(block *BlockStatement, modifier ModifierFunc) *BlockStatement {
+    if block == nil {
+        return nil
+    }
+    if modified, ok := Modify(block, modifier).(*BlockStatement); ok {
+        return modified
+    }
+    return block
+}
+
+func modifyIdentifier(id *Identifier, modifier ModifierFunc) *Identifier {
+    if id == nil {
+        return nil
+    }
+    if modified, ok := Modify(id, modifier).(*Identifier); ok {
+        return modified
+    }
+    return id
+}
+
+func modifyParams(params []*Identifier, modifier ModifierFunc) {
+    for i, param := range params {
+        params[i] = modifyIdentifier(param, modifier)
+    }
+}
+
+// Copy returns a deep copy of the tree below node, so that it can be modified without changing node.
+// Tokens are shared, they are never modified.
+func Copy(node Node) Node {
+    switch node := node.(type) {
+    case *Program:
+        return &Program{Statements: copyStatements(node.Statements), Comments: node.Comments}
+    case *ExpressionStatement:
+        return &ExpressionStatement{Token: node.Token, Expression: copyExpression(node.Expression)}
+    case *BlockStatement:
+        return copyBlock(node)
+    case *LetStatement:
+        return &LetStatement{Token: node.Token, Name: copyIdentifier(node.Name), Value: copyExpression(node.Value)}
+    case *ReturnStatement:
+        return &ReturnStatement{Token: node.Token, ReturnValue: copyExpression(node.ReturnValue)}
+    case *PrefixExpression:
+        return &PrefixExpression{Token: node.Token, Operator: node.Operator, Right: copyExpression(node.Right)}
+    case *InfixExpression:
+        return &InfixExpression{
+            Token:    node.Token,
+            Left:     copyExpression(node.Left),
+            Operator: node.Operator,
+            Right:    copyExpression(node.Right),
+        }
+    case *AssignExpression:
+        return &AssignExpression{
+            Token:    node.Token,
+            Target:   copyExpression(node.Target),
+            Operator: node.Operator,
+            Value:    copyExpression(node.Value),
+        }
+    case *IfExpression:
+        return &IfExpression{
+            Token:       node.Token,
+            Condition:   copyExpression(node.Condition),
+            Consequence: copyBlock(node.Consequence),
+            Alternative: copyBlock(node.Alternative),
+        }
+    case *CallExpression:
+        return &CallExpression{
+            Token:     node.Token,
+            Function:  copyExpression(node.Function),
+            Arguments: copyExpressions(node.Arguments),
+        }
+    case *IndexExpression:
+        return &IndexExpression{Token: node.Token, Left: copyExpression(node.Left), Index: copyExpression(node.Index)}
+    case *Function:
+        return &Function{Token: node.Token, Params: copyIdentifiers(node.Params), Body: copyBlock(node.Body)}
+    case *MacroLiteral:
+        return &MacroLiteral{Token: node.Token, Params: copyIdentifiers(node.Params), Body: copyBlock(node.Body)}
+    case *ArrayLiteral:
+        return &ArrayLiteral{Token: node.Token, Elements: copyExpressions(node.Elements)}
+    case *HashLiteral:
+        pairs := make([]HashPair, len(node.Pairs))
+        for i, pair := range node.Pairs {
+            pairs[i] = HashPair{Key: copyExpression(pair.Key), Value: copyExpression(pair.Value)}
+        }
+        return &HashLiteral{Token: node.Token, Pairs: pairs}
+    case *Identifier:
+        return copyIdentifier(node)
+    case *Integer:
+        copied := *node
+        return &copied
+    case *FloatLiteral:
+        copied := *node
+        return &copied
+    case *StringLiteral:
+        copied := *node
+        return &copied
+    case *Boolean:
+        copied := *node
+        return &copied
+    }
+    return node
+}
+
+func copyExpression(expr Expression) Expression {
+    if expr == nil {
+        return nil
+    }
+    copied, _ := Copy(expr).(Expression)
+    return copied
+}
+
+func copyExpressions(exprs []Expression) []Expression {
+    if exprs == nil {
+        return nil
+    }
+    copied := make([]Expression, len(exprs))
+    for i, expr := range exprs {
+        copied[i] = copyExpression(expr)
+    }
+    return copied
+}
+
+func copyStatements(stmts []Statement) []Statement {
+    if stmts == nil {
+        return nil
+    }
+    copied := make([]Statement, len(stmts))
+    for i, stmt := range stmts {
+        copied[i], _ = Copy(stmt).(Statement)
+    }
+    return copied
+}
+
+func copyBlock(block *BlockStatement) *BlockStatement {
+    if block == nil {
+        return nil
+    }
+    return &BlockStatement{Token: block.Token, Statements: copyStatements(block.Statements), Rbrace: block.Rbrace}
+}
+
+func copyIdentifier(id *Identifier) *Identifier {
+    if id == nil {
+        return nil
+    }
+    copied := *id
+    return &copied
+}
+
+func copyIdentifiers(ids []*Identifier) []*Identifier {
+    if ids == nil {
+        return nil
+    }
+    copied := make([]*Identifier, len(ids))
+    for i, id := range ids {
+        copied[i] = copyIdentifier(id)
+    }
+    return copied
+}
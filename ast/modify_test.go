@@ -0,0 +1,94 @@
+package ast
+
+import (
+    "monkey/token"
+    "testing"
+)
+
+func parse(t *testing.T, input string) *Program {
+    program, errs := NewParser(token.NewLexer(input)).Parse()
+    if len(errs) != 0 {
+        t.Fatalf("%q: parse errors %v", input, errs)
+    }
+    return program
+}
+
+// turnOneIntoTwo replaces every integer literal 1 with 2.
+func turnOneIntoTwo(node Node) Node {
+    integer, ok := node.(*Integer)
+    if !ok || integer.Value != 1 {
+        return node
+    }
+    return &Integer{Token: &token.Token{Type: token.Int, Literal: "2", Pos: integer.Pos()}, Value: 2}
+}
+
+func TestModify(t *testing.T) {
+    tests := []struct {
+        input    string
+        expected string
+    }{
+        {"1", "2\n"},
+        {"1 + 1", "(2+2)\n"},
+        {"-1", "(-2)\n"},
+        {"a[1]", "(a[2])\n"},
+        {"let x = 1", "let x = 2\n\n"},
+        {"return 1", "return 2\n"},
+        {"a[1] = 1", "((a[2])=2)\n"},
+        {"[1, 2, 1]", "[2,2,2]\n"},
+        {"{1: 1}", "{2:2}\n"},
+        {"f(1)", "f(2)\n"},
+        {"if (1) { 1 } else { 1 }", "if 2 {\n\t2\n}\nelse {\n\t2\n}\n\n\n"},
+        {"fn(x) { 1 }", "(x) {\n\t2\n}\n\n"},
+        {"macro(x) { 1 }", "macro(x) {\n\t2\n}\n\n"},
+    }
+
+    for _, tt := range tests {
+        modified := Modify(parse(t, tt.input), turnOneIntoTwo)
+        if modified.String() != tt.expected {
+            t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, modified.String())
+        }
+    }
+}
+
+func TestModifyIdentifiers(t *testing.T) {
+    program := parse(t, "let x = fn(x, y) { x + y }; x(1, 2)")
+    modified := Modify(program, func(node Node) Node {
+        if id, ok := node.(*Identifier); ok && id.Value == "x" {
+            return &Identifier{Token: id.Token, Value: "z"}
+        }
+        return node
+    })
+
+    expected := "let z = (z,y) {\n\t(z+y)\n}\n\n\nz(1,2)\n"
+    if modified.String() != expected {
+        t.Errorf("expected %q, got %q", expected, modified.String())
+    }
+}
+
+func TestModifyKeepsMisfits(t *testing.T) {
+    program := parse(t, "1 + 2")
+    modified := Modify(program, func(node Node) Node {
+        if _, ok := node.(*Integer); ok {
+            return &BlockStatement{Token: &token.Token{Type: token.Lbrace, Literal: "{"}}
+        }
+        return node
+    })
+
+    if modified.String() != "(1+2)\n" {
+        t.Errorf("expected misfitting replacements to be ignored, got %q", modified.String())
+    }
+}
+
+func TestCopy(t *testing.T) {
+    program := parse(t, "let f = fn(x) { if (x) { [1, {1: x}] } else { f(x - 1)[0] } }; macro(a) { -1 }")
+    expected := program.String()
+
+    copied := Copy(program)
+    Modify(copied, turnOneIntoTwo)
+    if program.String() != expected {
+        t.Errorf("modifying the copy changed the original: %q", program.String())
+    }
+    if copied.String() == expected {
+        t.Errorf("the copy was not modified: %q", copied.String())
+    }
+}
@@ -1,9 +1,7 @@
 package ast
 
 import (
-    "errors"
     "fmt"
-    "log"
     token2 "monkey/token"
     "strconv"
 )
@@ -11,24 +9,62 @@ import (
 const (
     _ = iota
     Lowest
+    Assignment
+    LogicalOr
+    LogicalAnd
     Equals
     LessGreater
+    BitOr
+    BitXor
+    BitAnd
+    Shift
     Sum
     Product
     Prefix
+    Power
     Call
+    Index
 )
 
 var Precedences = map[token2.Type]int{
+    token2.Assign:   Assignment,
+    token2.PlusEq:   Assignment,
+    token2.MinusEq:  Assignment,
+    token2.TimesEq:  Assignment,
+    token2.DivideEq: Assignment,
     token2.Eq:       Equals,
     token2.Ne:       Equals,
     token2.Lt:       LessGreater,
     token2.Gt:       LessGreater,
+    token2.Le:       LessGreater,
+    token2.Ge:       LessGreater,
+    token2.And:      LogicalAnd,
+    token2.Or:       LogicalOr,
     token2.Plus:     Sum,
     token2.Minus:    Sum,
     token2.Asterisk: Product,
     token2.Slash:    Product,
+    token2.Percent:  Product,
+    token2.Power:    Power,
+    token2.BitOr:    BitOr,
+    token2.BitXor:   BitXor,
+    token2.BitAnd:   BitAnd,
+    token2.Shl:      Shift,
+    token2.Shr:      Shift,
     token2.Lparen:   Call,
+    token2.Lbracket: Index,
+}
+
+// ParseError describes a syntax error. Expected is empty when the error is not about a missing token.
+type ParseError struct {
+    Message  string
+    Expected token2.Type
+    Got      token2.Type
+    Pos      token2.Position
+}
+
+func (err ParseError) Error() string {
+    return fmt.Sprintf("%s: %s", err.Pos, err.Message)
 }
 
 type (
@@ -38,7 +74,7 @@ type (
 
 type Parser struct {
     lexer               *token2.Lexer
-    errors              []error
+    errors              []ParseError
     currentToken        *token2.Token
     peekToken           *token2.Token
     prefixExprResolvers map[string]PrefixExpressionResolver
@@ -53,13 +89,19 @@ func NewParser(l *token2.Lexer) *Parser {
     parser.prefixExprResolvers = make(map[string]PrefixExpressionResolver)
     parser.registerPrefix(token2.Ident, parser.parseIdentifier)
     parser.registerPrefix(token2.Int, parser.parseInteger)
+    parser.registerPrefix(token2.Float, parser.parseFloat)
+    parser.registerPrefix(token2.String, parser.parseStringLiteral)
     parser.registerPrefix(token2.Bang, parser.parsePrefixExpression)
     parser.registerPrefix(token2.Minus, parser.parsePrefixExpression)
+    parser.registerPrefix(token2.BitNot, parser.parsePrefixExpression)
     parser.registerPrefix(token2.True, parser.parseBoolean)
     parser.registerPrefix(token2.False, parser.parseBoolean)
     parser.registerPrefix(token2.Lparen, parser.parseGroupedExpression)
     parser.registerPrefix(token2.If, parser.parseIfExpression)
     parser.registerPrefix(token2.Function, parser.parseFunction)
+    parser.registerPrefix(token2.Macro, parser.parseMacroLiteral)
+    parser.registerPrefix(token2.Lbracket, parser.parseArrayLiteral)
+    parser.registerPrefix(token2.Lbrace, parser.parseHashLiteral)
 
     parser.infixExprResolvers = make(map[string]InfixExpressionResolver)
     parser.registerInfix(token2.Plus, parser.parseInfixExpression)
@@ -70,7 +112,24 @@ func NewParser(l *token2.Lexer) *Parser {
     parser.registerInfix(token2.Ne, parser.parseInfixExpression)
     parser.registerInfix(token2.Lt, parser.parseInfixExpression)
     parser.registerInfix(token2.Gt, parser.parseInfixExpression)
+    parser.registerInfix(token2.Le, parser.parseInfixExpression)
+    parser.registerInfix(token2.Ge, parser.parseInfixExpression)
+    parser.registerInfix(token2.And, parser.parseInfixExpression)
+    parser.registerInfix(token2.Or, parser.parseInfixExpression)
+    parser.registerInfix(token2.Percent, parser.parseInfixExpression)
+    parser.registerInfix(token2.Power, parser.parseInfixExpression)
+    parser.registerInfix(token2.BitAnd, parser.parseInfixExpression)
+    parser.registerInfix(token2.BitOr, parser.parseInfixExpression)
+    parser.registerInfix(token2.BitXor, parser.parseInfixExpression)
+    parser.registerInfix(token2.Shl, parser.parseInfixExpression)
+    parser.registerInfix(token2.Shr, parser.parseInfixExpression)
+    parser.registerInfix(token2.Assign, parser.parseAssignExpression)
+    parser.registerInfix(token2.PlusEq, parser.parseAssignExpression)
+    parser.registerInfix(token2.MinusEq, parser.parseAssignExpression)
+    parser.registerInfix(token2.TimesEq, parser.parseAssignExpression)
+    parser.registerInfix(token2.DivideEq, parser.parseAssignExpression)
     parser.registerInfix(token2.Lparen, parser.parseCallExpression)
+    parser.registerInfix(token2.Lbracket, parser.parseIndexExpression)
 
     return parser
 }
@@ -80,23 +139,38 @@ func (parser *Parser) nextToken() {
     parser.peekToken = parser.lexer.NextToken()
 }
 
-func (parser *Parser) Parse() *Program {
-    program := &Program{Statements: make([]Statement, 2)}
+// Parse parses the whole input. The returned program is incomplete if any errors are reported.
+func (parser *Parser) Parse() (*Program, []ParseError) {
+    program := &Program{Statements: []Statement{}}
     for parser.currentToken.Type != token2.Eof {
+        errCount := len(parser.errors)
         statement := parser.parseStatement()
-        program.Statements = append(program.Statements, statement)
+        if len(parser.errors) > errCount {
+            parser.synchronize()
+        } else if statement != nil {
+            program.Statements = append(program.Statements, statement)
+        }
         parser.nextToken()
     }
-    if len(parser.errors) != 0 {
-        log.Fatal(parser.errors)
+    program.Comments = parser.lexer.Comments()
+    return program, parser.errors
+}
+
+// synchronize skips the rest of a broken statement so that one syntax error is not reported many times.
+func (parser *Parser) synchronize() {
+    for !parser.currentTokenIs(token2.Semicolon) && !parser.currentTokenIs(token2.Eof) {
+        parser.nextToken()
     }
-    return program
 }
 
 func (parser *Parser) parseStatement() Statement {
     switch parser.currentToken.Type {
     case token2.Let:
-        return parser.parseLetStatement()
+        // avoid wrapping a nil *LetStatement into a non-nil Statement
+        if letStmt := parser.parseLetStatement(); letStmt != nil {
+            return letStmt
+        }
+        return nil
     case token2.Return:
         return parser.parseReturnStatement()
     default:
@@ -128,7 +202,7 @@ func (parser *Parser) parseLetStatement() (letStmt *LetStatement) {
     parser.nextToken()
     letStmt.Value = parser.parseExpression(Lowest)
 
-    if !parser.currentTokenIs(token2.Semicolon) {
+    if parser.peekTokenIs(token2.Semicolon) {
         parser.nextToken()
     }
     return letStmt
@@ -141,7 +215,7 @@ func (parser *Parser) parseReturnStatement() (stmt *ReturnStatement) {
     parser.nextToken()
     stmt.ReturnValue = parser.parseExpression(Lowest)
 
-    if !parser.currentTokenIs(token2.Semicolon) {
+    if parser.peekTokenIs(token2.Semicolon) {
         parser.nextToken()
     }
     return stmt
@@ -149,10 +223,8 @@ func (parser *Parser) parseReturnStatement() (stmt *ReturnStatement) {
 
 //  x + y;
 func (parser *Parser) parseExpressionStatement() (stmt *ExpressionStatement) {
-    stmt = &ExpressionStatement{
-        Token:      parser.currentToken,
-        Expression: parser.parseExpression(Lowest),
-    }
+    stmt = &ExpressionStatement{Token: parser.currentToken}
+    stmt.Expression = parser.parseExpression(Lowest)
 
     if parser.peekTokenIs(token2.Semicolon) {
         parser.nextToken()
@@ -163,12 +235,21 @@ func (parser *Parser) parseExpressionStatement() (stmt *ExpressionStatement) {
 func (parser *Parser) parseExpression(precedence int) Expression {
     prefix := parser.prefixExprResolvers[parser.currentToken.Type]
     if prefix == nil {
-        parser.error(errors.New(fmt.Sprintf("no prefix for %s found", parser.currentToken.Type)))
+        message := fmt.Sprintf("unexpected %s", parser.currentToken.Type)
+        if parser.currentTokenIs(token2.Illegal) {
+            message = fmt.Sprintf("illegal token %q", parser.currentToken.Literal)
+        }
+        parser.error(ParseError{
+            Message: message,
+            Got:     parser.currentToken.Type,
+            Pos:     parser.currentToken.Pos,
+        })
         return nil
     }
     left := prefix()
 
-    for precedence < parser.peekPrecedence() {
+    // a nil left has already been reported and an infix operator after it would have no operand
+    for left != nil && precedence < parser.peekPrecedence() {
         t := parser.peekToken.Type
         infix := parser.infixExprResolvers[t]
         if infix == nil {
@@ -209,14 +290,44 @@ func (parser *Parser) parseInfixExpression(leftExpr Expression) Expression {
     }
 
     precedence := parser.currentPrecedence()
+    if infixExpr.Token.Type == token2.Power {
+        // ** is right associative: the right operand may contain further ** itself
+        precedence--
+    }
     parser.nextToken()
     infixExpr.Right = parser.parseExpression(precedence)
     return infixExpr
 }
 
+// parseAssignExpression parses an assignment to target. The = of a let statement never gets here, it
+// is consumed by parseLetStatement.
+func (parser *Parser) parseAssignExpression(target Expression) Expression {
+    assignExpr := &AssignExpression{
+        Token:    parser.currentToken,
+        Target:   target,
+        Operator: parser.currentToken.Literal,
+        Value:    nil,
+    }
+    // assignments are right associative: a = b = c assigns c to b first
+    parser.nextToken()
+    assignExpr.Value = parser.parseExpression(Assignment - 1)
+
+    switch target.(type) {
+    case *Identifier, *IndexExpression:
+        return assignExpr
+    default:
+        parser.error(ParseError{
+            Message: fmt.Sprintf("cannot assign to %s", target.String()),
+            Got:     assignExpr.Token.Type,
+            Pos:     assignExpr.Token.Pos,
+        })
+        return nil
+    }
+}
+
 func (parser *Parser) parseIfExpression() Expression {
     ifExpr := &IfExpression{
-        Token:       nil,
+        Token:       parser.currentToken,
         Condition:   nil,
         Consequence: nil,
         Alternative: nil,
@@ -253,61 +364,163 @@ func (parser *Parser) parseIfExpression() Expression {
 func (parser *Parser) parseFunction() Expression {
     function := Function{
         Token:  parser.currentToken,
-        Params: []*Identifier{},
+        Params: nil,
         Body:   nil,
     }
-    if !parser.assertPeekTokenIs(token2.Lparen) {
+
+    function.Params = parser.parseParams()
+    if function.Params == nil || !parser.assertPeekTokenIs(token2.Lbrace) {
         return nil
     }
 
-    // parse params
-    if !parser.peekTokenIs(token2.Rparen) {
-        for {
-            parser.assertPeekTokenIs(token2.Ident)
-            function.Params = append(function.Params, &Identifier{
-                Token: parser.currentToken,
-                Value: parser.currentToken.Literal,
-            })
-
-            parser.nextToken()
-            if parser.currentTokenIs(token2.Comma) {
-                continue
-            } else if parser.currentTokenIs(token2.Rparen) {
-                break
-            }
-        }
+    function.Body = parser.parseBlockStatement()
+    return &function
+}
+
+// macro(x, y) { ... }
+func (parser *Parser) parseMacroLiteral() Expression {
+    macro := &MacroLiteral{
+        Token:  parser.currentToken,
+        Params: nil,
+        Body:   nil,
     }
 
-    if !parser.assertPeekTokenIs(token2.Lbrace) {
+    macro.Params = parser.parseParams()
+    if macro.Params == nil || !parser.assertPeekTokenIs(token2.Lbrace) {
         return nil
     }
 
-    function.Body = parser.parseBlockStatement()
-    return &function
+    macro.Body = parser.parseBlockStatement()
+    return macro
+}
+
+// parseParams parses a parenthesized parameter list following the current token.
+// It returns nil on a syntax error and an empty list for ().
+func (parser *Parser) parseParams() []*Identifier {
+    params := []*Identifier{}
+    if !parser.assertPeekTokenIs(token2.Lparen) {
+        return nil
+    }
+
+    if parser.peekTokenIs(token2.Rparen) {
+        parser.nextToken()
+        return params
+    }
+
+    for {
+        if !parser.assertPeekTokenIs(token2.Ident) {
+            return nil
+        }
+        params = append(params, &Identifier{
+            Token: parser.currentToken,
+            Value: parser.currentToken.Literal,
+        })
+
+        parser.nextToken()
+        if parser.currentTokenIs(token2.Comma) {
+            continue
+        } else if parser.currentTokenIs(token2.Rparen) {
+            return params
+        }
+        parser.error(ParseError{
+            Message:  fmt.Sprintf("expected , or ) in parameter list, got %s", parser.currentToken.Type),
+            Expected: token2.Rparen,
+            Got:      parser.currentToken.Type,
+            Pos:      parser.currentToken.Pos,
+        })
+        return nil
+    }
 }
 
 func (parser *Parser) parseCallExpression(function Expression) Expression {
     callExpr := &CallExpression{
         Token:     parser.currentToken,
         Function:  function,
-        Arguments: []Expression{},
+        Arguments: nil,
     }
 
-    parser.nextToken()
-    // parse params
-    if !parser.currentTokenIs(token2.Rparen) {
-        callExpr.Arguments = append(callExpr.Arguments, parser.parseExpression(Lowest))
-        for parser.peekTokenIs(token2.Comma) {
-            parser.nextToken()
-            parser.nextToken()
-            callExpr.Arguments = append(callExpr.Arguments, parser.parseExpression(Lowest))
+    callExpr.Arguments = parser.parseExpressionList(token2.Rparen)
+    if callExpr.Arguments == nil {
+        return nil
+    }
+    return callExpr
+}
+
+// [1, 2, 3]
+func (parser *Parser) parseArrayLiteral() Expression {
+    array := &ArrayLiteral{Token: parser.currentToken}
+
+    array.Elements = parser.parseExpressionList(token2.Rbracket)
+    if array.Elements == nil {
+        return nil
+    }
+    return array
+}
+
+// {"a": 1, true: 2}
+// Blocks only appear after if, else and fn and are parsed by parseBlockStatement,
+// so a { reaching the prefix resolvers always starts a hash literal.
+func (parser *Parser) parseHashLiteral() Expression {
+    hash := &HashLiteral{Token: parser.currentToken, Pairs: []HashPair{}}
+
+    for !parser.peekTokenIs(token2.Rbrace) {
+        parser.nextToken()
+        key := parser.parseExpression(Lowest)
+        if !parser.assertPeekTokenIs(token2.Colon) {
+            return nil
         }
-        if !parser.assertPeekTokenIs(token2.Rparen) {
+
+        parser.nextToken()
+        value := parser.parseExpression(Lowest)
+        hash.Pairs = append(hash.Pairs, HashPair{Key: key, Value: value})
+
+        if !parser.peekTokenIs(token2.Rbrace) && !parser.assertPeekTokenIs(token2.Comma) {
             return nil
         }
     }
 
-    return callExpr
+    if !parser.assertPeekTokenIs(token2.Rbrace) {
+        return nil
+    }
+    return hash
+}
+
+// arr[1]
+func (parser *Parser) parseIndexExpression(left Expression) Expression {
+    indexExpr := &IndexExpression{
+        Token: parser.currentToken,
+        Left:  left,
+        Index: nil,
+    }
+
+    parser.nextToken()
+    indexExpr.Index = parser.parseExpression(Lowest)
+    if !parser.assertPeekTokenIs(token2.Rbracket) {
+        return nil
+    }
+    return indexExpr
+}
+
+// parseExpressionList parses comma separated expressions up to the end token.
+// It returns nil on a syntax error and an empty list for an empty pair of delimiters.
+func (parser *Parser) parseExpressionList(end token2.Type) []Expression {
+    exprs := []Expression{}
+
+    parser.nextToken()
+    if parser.currentTokenIs(end) {
+        return exprs
+    }
+
+    exprs = append(exprs, parser.parseExpression(Lowest))
+    for parser.peekTokenIs(token2.Comma) {
+        parser.nextToken()
+        parser.nextToken()
+        exprs = append(exprs, parser.parseExpression(Lowest))
+    }
+    if !parser.assertPeekTokenIs(end) {
+        return nil
+    }
+    return exprs
 }
 
 func (parser *Parser) parseBlockStatement() *BlockStatement {
@@ -324,6 +537,9 @@ func (parser *Parser) parseBlockStatement() *BlockStatement {
         }
         parser.nextToken()
     }
+    if parser.currentTokenIs(token2.Rbrace) {
+        blockStmt.Rbrace = parser.currentToken
+    }
 
     return &blockStmt
 }
@@ -342,13 +558,39 @@ func (parser *Parser) parseInteger() Expression {
 
     value, err := strconv.ParseInt(parser.currentToken.Literal, 0, 64)
     if err != nil {
-        parser.error(errors.New(fmt.Sprintf("could not parse %q as integer", integer.Token)))
+        parser.error(ParseError{
+            Message: fmt.Sprintf("could not parse %q as integer", integer.Token.Literal),
+            Got:     integer.Token.Type,
+            Pos:     integer.Token.Pos,
+        })
     }
 
     integer.Value = value
     return integer
 }
 
+// 1.5, 1e-3
+func (parser *Parser) parseFloat() Expression {
+    float := &FloatLiteral{Token: parser.currentToken}
+
+    value, err := strconv.ParseFloat(parser.currentToken.Literal, 64)
+    if err != nil {
+        parser.error(ParseError{
+            Message: fmt.Sprintf("could not parse %q as float", float.Token.Literal),
+            Got:     float.Token.Type,
+            Pos:     float.Token.Pos,
+        })
+    }
+
+    float.Value = value
+    return float
+}
+
+// "hello"
+func (parser *Parser) parseStringLiteral() Expression {
+    return &StringLiteral{Token: parser.currentToken, Value: parser.currentToken.Literal}
+}
+
 func (parser *Parser) parseBoolean() Expression {
     return &Boolean{
         Token: parser.currentToken,
@@ -361,7 +603,12 @@ func (parser *Parser) assertPeekTokenIs(tokenType token2.Type) bool {
         parser.nextToken()
         return true
     }
-    parser.error(errors.New(fmt.Sprintf("expected next token is %s, but got %s", tokenType, parser.peekToken.Type)))
+    parser.error(ParseError{
+        Message:  fmt.Sprintf("expected next token to be %s, got %s", tokenType, parser.peekToken.Type),
+        Expected: tokenType,
+        Got:      parser.peekToken.Type,
+        Pos:      parser.peekToken.Pos,
+    })
     return false
 }
 
@@ -381,7 +628,7 @@ func (parser *Parser) registerInfix(prefix string, resolver InfixExpressionResol
     parser.infixExprResolvers[prefix] = resolver
 }
 
-func (parser *Parser) error(err error) {
+func (parser *Parser) error(err ParseError) {
     parser.errors = append(parser.errors, err)
 }
 
@@ -12,6 +12,109 @@ var i = `a;`
 
 func Test(t *testing.T) {
     parser := NewParser(token.NewLexer(input))
-    program := parser.Parse()
+    program, _ := parser.Parse()
     println(program.String())
 }
+
+func TestParseErrors(t *testing.T) {
+    tests := []struct {
+        input    string
+        expected []ParseError
+    }{
+        {"let = 5;", []ParseError{
+            {Expected: token.Ident, Got: token.Assign, Pos: token.Position{Offset: 4, Line: 1, Column: 5, ByteColumn: 5}},
+        }},
+        {"let x = 1;\nlet y 2;", []ParseError{
+            {Expected: token.Assign, Got: token.Int, Pos: token.Position{Offset: 17, Line: 2, Column: 7, ByteColumn: 7}},
+        }},
+        {"fn(a b) {}", []ParseError{
+            {Expected: token.Rparen, Got: token.Ident, Pos: token.Position{Offset: 5, Line: 1, Column: 6, ByteColumn: 6}},
+        }},
+        {"\n  )", []ParseError{
+            {Got: token.Rparen, Pos: token.Position{Offset: 3, Line: 2, Column: 3, ByteColumn: 3}},
+        }},
+        {"let x = 0b102;", []ParseError{
+            {Got: token.Illegal, Pos: token.Position{Offset: 8, Line: 1, Column: 9, ByteColumn: 9}},
+        }},
+        {"let x = 0755;", []ParseError{
+            {Got: token.Illegal, Pos: token.Position{Offset: 8, Line: 1, Column: 9, ByteColumn: 9}},
+        }},
+        {"08", []ParseError{
+            {Got: token.Illegal, Pos: token.Position{Offset: 0, Line: 1, Column: 1, ByteColumn: 1}},
+        }},
+        {"0x1_0000_0000_0000_0000", []ParseError{
+            {Got: token.Int, Pos: token.Position{Offset: 0, Line: 1, Column: 1, ByteColumn: 1}},
+        }},
+        {"1 = 2;", []ParseError{
+            {Got: token.Assign, Pos: token.Position{Offset: 2, Line: 1, Column: 3, ByteColumn: 3}},
+        }},
+        {"a + b += 1;", []ParseError{
+            {Got: token.PlusEq, Pos: token.Position{Offset: 6, Line: 1, Column: 7, ByteColumn: 7}},
+        }},
+        {"let x = f() = 3;", []ParseError{
+            {Got: token.Assign, Pos: token.Position{Offset: 12, Line: 1, Column: 13, ByteColumn: 13}},
+        }},
+        {"fn(x) = 1", []ParseError{
+            {Expected: token.Lbrace, Got: token.Assign, Pos: token.Position{Offset: 6, Line: 1, Column: 7, ByteColumn: 7}},
+        }},
+        {"if (x) = 1", []ParseError{
+            {Expected: token.Lbrace, Got: token.Assign, Pos: token.Position{Offset: 7, Line: 1, Column: 8, ByteColumn: 8}},
+        }},
+    }
+
+    for _, tt := range tests {
+        _, errs := NewParser(token.NewLexer(tt.input)).Parse()
+        if len(errs) != len(tt.expected) {
+            t.Errorf("%q: expected %d errors, got %v", tt.input, len(tt.expected), errs)
+            continue
+        }
+        for i, err := range errs {
+            want := tt.expected[i]
+            if err.Expected != want.Expected || err.Got != want.Got || err.Pos != want.Pos {
+                t.Errorf("%q: expected %+v, got %+v", tt.input, want, err)
+            }
+        }
+    }
+}
+
+func TestOperatorPrecedence(t *testing.T) {
+    tests := []struct {
+        input    string
+        expected string
+    }{
+        {"-a * b", "((-a)*b)"},
+        {"a + b * c - d", "((a+(b*c))-d)"},
+        {"a < b == c > d", "((a<b)==(c>d))"},
+        {"a * [1, 2][b]", "(a*([1,2][b]))"},
+        {"add(a)[0] + xs[1][2]", "((add(a)[0])+((xs[1])[2]))"},
+        {`{"a": 1 + 2, b: c}["a"]`, `({"a":(1+2),b:c}["a"])`},
+        {"a <= b == c >= d", "((a<=b)==(c>=d))"},
+        {"2 ** 3 ** 2", "(2**(3**2))"},
+        {"-2 ** 2", "(-(2**2))"},
+        {"2 ** -1 * 3", "((2**(-1))*3)"},
+        {"a * b % c ** d", "((a*b)%(c**d))"},
+        {"a | b ^ c & d", "(a|(b^(c&d)))"},
+        {"1 << 2 + 3 >> 4", "((1<<(2+3))>>4)"},
+        {"a & b == c", "((a&b)==c)"},
+        {"a < b | c && d", "((a<(b|c))&&d)"},
+        {"~a & ~b", "((~a)&(~b))"},
+        {"a = b = c", "(a=(b=c))"},
+        {"x += 1 * 2", "(x+=(1*2))"},
+        {"a[i] -= b || c", "((a[i])-=(b||c))"},
+        {"h[k][0] /= f(x = 2)", "(((h[k])[0])/=f((x=2)))"},
+        {"a || b && c", "(a||(b&&c))"},
+        {"a && b || c && d", "((a&&b)||(c&&d))"},
+        {"a == b && !c || d < e", "(((a==b)&&(!c))||(d<e))"},
+    }
+
+    for _, tt := range tests {
+        program, errs := NewParser(token.NewLexer(tt.input)).Parse()
+        if len(errs) != 0 {
+            t.Errorf("%q: unexpected errors %v", tt.input, errs)
+            continue
+        }
+        if actual := program.Statements[0].String(); actual != tt.expected {
+            t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, actual)
+        }
+    }
+}
@@ -0,0 +1,114 @@
+package ast
+
+// A Visitor's Visit method is called by Walk for every node. If it returns a non-nil visitor w,
+// the children of node are visited with w, followed by a call of w.Visit(nil).
+type Visitor interface {
+    Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree below node depth-first, parents before their children and children in source order.
+func Walk(visitor Visitor, node Node) {
+    if visitor = visitor.Visit(node); visitor == nil {
+        return
+    }
+
+    switch node := node.(type) {
+    case *Program:
+        walkStatements(visitor, node.Statements)
+    case *ExpressionStatement:
+        walkExpression(visitor, node.Expression)
+    case *BlockStatement:
+        walkStatements(visitor, node.Statements)
+    case *LetStatement:
+        if node.Name != nil {
+            Walk(visitor, node.Name)
+        }
+        walkExpression(visitor, node.Value)
+    case *ReturnStatement:
+        walkExpression(visitor, node.ReturnValue)
+    case *PrefixExpression:
+        walkExpression(visitor, node.Right)
+    case *InfixExpression:
+        walkExpression(visitor, node.Left)
+        walkExpression(visitor, node.Right)
+    case *AssignExpression:
+        walkExpression(visitor, node.Target)
+        walkExpression(visitor, node.Value)
+    case *IfExpression:
+        walkExpression(visitor, node.Condition)
+        if node.Consequence != nil {
+            Walk(visitor, node.Consequence)
+        }
+        if node.Alternative != nil {
+            Walk(visitor, node.Alternative)
+        }
+    case *CallExpression:
+        walkExpression(visitor, node.Function)
+        walkExpressions(visitor, node.Arguments)
+    case *IndexExpression:
+        walkExpression(visitor, node.Left)
+        walkExpression(visitor, node.Index)
+    case *Function:
+        walkParams(visitor, node.Params)
+        if node.Body != nil {
+            Walk(visitor, node.Body)
+        }
+    case *MacroLiteral:
+        walkParams(visitor, node.Params)
+        if node.Body != nil {
+            Walk(visitor, node.Body)
+        }
+    case *ArrayLiteral:
+        walkExpressions(visitor, node.Elements)
+    case *HashLiteral:
+        for _, pair := range node.Pairs {
+            walkExpression(visitor, pair.Key)
+            walkExpression(visitor, pair.Value)
+        }
+    }
+
+    visitor.Visit(nil)
+}
+
+// Inspect walks the tree below node, calling f for every node and with nil after the children of a node.
+// The children of a node are skipped if f returns false for it.
+func Inspect(node Node, f func(Node) bool) {
+    Walk(inspector(f), node)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+    if f(node) {
+        return f
+    }
+    return nil
+}
+
+func walkExpression(visitor Visitor, expr Expression) {
+    if expr != nil {
+        Walk(visitor, expr)
+    }
+}
+
+func walkExpressions(visitor Visitor, exprs []Expression) {
+    for _, expr := range exprs {
+        walkExpression(visitor, expr)
+    }
+}
+
+func walkStatements(visitor Visitor, stmts []Statement) {
+    for _, stmt := range stmts {
+        if stmt != nil {
+            Walk(visitor, stmt)
+        }
+    }
+}
+
+func walkParams(visitor Visitor, params []*Identifier) {
+    for _, param := range params {
+        if param != nil {
+            Walk(visitor, param)
+        }
+    }
+}
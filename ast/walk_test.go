@@ -0,0 +1,83 @@
+package ast
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+)
+
+func TestWalk(t *testing.T) {
+    tests := []struct {
+        input    string
+        expected string
+    }{
+        {"1 + 2", "*ast.Program *ast.ExpressionStatement *ast.InfixExpression *ast.Integer *ast.Integer"},
+        {"let x = -a[0];", "*ast.Program *ast.LetStatement *ast.Identifier *ast.PrefixExpression " +
+            "*ast.IndexExpression *ast.Identifier *ast.Integer"},
+        {"return f(true, \"s\");", "*ast.Program *ast.ReturnStatement *ast.CallExpression *ast.Identifier " +
+            "*ast.Boolean *ast.StringLiteral"},
+        {"if (a) { b } else { c }", "*ast.Program *ast.ExpressionStatement *ast.IfExpression *ast.Identifier " +
+            "*ast.BlockStatement *ast.ExpressionStatement *ast.Identifier " +
+            "*ast.BlockStatement *ast.ExpressionStatement *ast.Identifier"},
+        {"fn(a) { a }", "*ast.Program *ast.ExpressionStatement *ast.Function *ast.Identifier " +
+            "*ast.BlockStatement *ast.ExpressionStatement *ast.Identifier"},
+        {"macro(a) { a }", "*ast.Program *ast.ExpressionStatement *ast.MacroLiteral *ast.Identifier " +
+            "*ast.BlockStatement *ast.ExpressionStatement *ast.Identifier"},
+        {"[1, {2: 3}]", "*ast.Program *ast.ExpressionStatement *ast.ArrayLiteral *ast.Integer " +
+            "*ast.HashLiteral *ast.Integer *ast.Integer"},
+    }
+
+    for _, tt := range tests {
+        var visited []string
+        Inspect(parse(t, tt.input), func(node Node) bool {
+            if node != nil {
+                visited = append(visited, fmt.Sprintf("%T", node))
+            }
+            return true
+        })
+        if strings.Join(visited, " ") != tt.expected {
+            t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, strings.Join(visited, " "))
+        }
+    }
+}
+
+// depthCounter records the depth of every integer in the tree.
+type depthCounter struct {
+    depth  int
+    depths *[]int
+}
+
+func (counter *depthCounter) Visit(node Node) Visitor {
+    if node == nil {
+        return nil
+    }
+    if _, ok := node.(*Integer); ok {
+        *counter.depths = append(*counter.depths, counter.depth)
+    }
+    return &depthCounter{depth: counter.depth + 1, depths: counter.depths}
+}
+
+func TestWalkVisitor(t *testing.T) {
+    var depths []int
+    Walk(&depthCounter{depths: &depths}, parse(t, "1 + (2 * 3)"))
+
+    expected := "[3 4 4]"
+    if fmt.Sprint(depths) != expected {
+        t.Errorf("expected depths %s, got %v", expected, depths)
+    }
+}
+
+func TestInspectSkipsChildren(t *testing.T) {
+    var integers int
+    Inspect(parse(t, "1; fn() { 2 + 3 }; [4]"), func(node Node) bool {
+        if _, ok := node.(*Integer); ok {
+            integers++
+        }
+        _, isFunction := node.(*Function)
+        return !isFunction
+    })
+
+    if integers != 2 {
+        t.Errorf("expected the function body to be skipped, counted %d integers", integers)
+    }
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/eval"
+	"monkey/token"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// buildFile compiles the script at path into a .mkc file at output, which defaults to path with the .mkc extension.
+func buildFile(path string, output string, stderr io.Writer) int {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	program, errs := ast.NewParser(token.NewLexer(string(source))).Parse()
+	if len(errs) != 0 {
+		for _, err := range errs {
+			fmt.Fprintf(stderr, "%s:%s\n", path, err)
+		}
+		return exitError
+	}
+
+	program, err = expandMacros(program, eval.NewEnvironment())
+	if err != nil {
+		fmt.Fprintf(stderr, "%s:%s\n", path, err)
+		return exitError
+	}
+
+	comp := compiler.NewCompiler()
+	if err := comp.Compile(program); err != nil {
+		if compileErr, ok := err.(*compiler.CompileError); ok {
+			fmt.Fprintf(stderr, "%s:%s: %s\n", path, compileErr.Pos, compileErr.Message)
+		} else {
+			fmt.Fprintf(stderr, "%s: %s\n", path, err)
+		}
+		return exitError
+	}
+
+	if output == "" {
+		output = strings.TrimSuffix(path, filepath.Ext(path)) + ".mkc"
+	}
+	// encode first, so that a failure leaves no file behind
+	var buffer bytes.Buffer
+	if err := compiler.Encode(&buffer, comp.Bytecode(), path); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	if err := os.WriteFile(output, buffer.Bytes(), 0o666); err != nil {
+		// remove what was written, unless output is e.g. a device
+		if info, statErr := os.Stat(output); statErr == nil && info.Mode().IsRegular() {
+			os.Remove(output)
+		}
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,70 @@
+package code
+
+import (
+	"monkey/token"
+	"testing"
+)
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected []byte
+	}{
+		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
+		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
+		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+		if string(instruction) != string(tt.expected) {
+			t.Errorf("expected %v, got %v", tt.expected, instruction)
+		}
+	}
+}
+
+func TestInstructionsString(t *testing.T) {
+	instructions := Instructions{}
+	for _, ins := range [][]byte{
+		Make(OpAdd),
+		Make(OpGetLocal, 1),
+		Make(OpConstant, 2),
+		Make(OpConstant, 65535),
+		Make(OpClosure, 65535, 255),
+	} {
+		instructions = append(instructions, ins...)
+	}
+
+	expected := `0000 OpAdd
+0001 OpGetLocal 1
+0003 OpConstant 2
+0006 OpConstant 65535
+0009 OpClosure 65535 255
+`
+	if instructions.String() != expected {
+		t.Errorf("expected %q, got %q", expected, instructions.String())
+	}
+}
+
+func TestLineTableLookup(t *testing.T) {
+	table := LineTable{
+		{Offset: 0, Pos: token.Position{Line: 1, Column: 1}},
+		{Offset: 3, Pos: token.Position{Line: 2, Column: 5}},
+	}
+	tests := map[int]int{0: 1, 2: 1, 3: 2, 10: 2}
+	for offset, line := range tests {
+		if pos := table.Lookup(offset); pos.Line != line {
+			t.Errorf("offset %d: expected line %d, got %d", offset, line, pos.Line)
+		}
+	}
+}
+
+func TestReadOperands(t *testing.T) {
+	def, _ := Lookup(byte(OpClosure))
+	operands, read := ReadOperands(def, Make(OpClosure, 65535, 255)[1:])
+	if read != 3 || operands[0] != 65535 || operands[1] != 255 {
+		t.Errorf("unexpected operands %v, read %d", operands, read)
+	}
+}
@@ -0,0 +1,25 @@
+package code
+
+import (
+	"monkey/token"
+	"sort"
+)
+
+// LineEntry marks the instruction at Offset as the first one compiled from the source at Pos.
+type LineEntry struct {
+	Offset int
+	Pos    token.Position
+}
+
+// LineTable maps instruction offsets back to source positions. Entries are sorted by offset,
+// and each one covers the instructions up to the next entry.
+type LineTable []LineEntry
+
+// Lookup returns the source position of the instruction containing offset.
+func (table LineTable) Lookup(offset int) token.Position {
+	i := sort.Search(len(table), func(i int) bool { return table[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}
+	}
+	return table[i-1].Pos
+}
@@ -0,0 +1,659 @@
+package compiler
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/eval"
+	"monkey/token"
+	"strings"
+)
+
+// Bytecode is the output of the compiler: the instructions of the main program and the constant pool they refer to.
+type Bytecode struct {
+	Instructions code.Instructions
+	Lines        code.LineTable
+	Constants    []eval.Object
+
+	// Globals are the names of the global slots, for the errors about unbound globals
+	Globals []string
+}
+
+// CompileError is reported for programs that parse but cannot be compiled, e.g. because of an assignment to a builtin.
+type CompileError struct {
+	Message string
+	Pos     token.Position
+}
+
+func (err *CompileError) Error() string {
+	return err.Message
+}
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// CompilationScope holds the instructions of the function body being compiled.
+type CompilationScope struct {
+	instructions        code.Instructions
+	lines               code.LineTable
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+}
+
+type Compiler struct {
+	constants   []eval.Object
+	symbolTable *SymbolTable
+	scopes      []CompilationScope
+	scopeIndex  int
+
+	// pos is the position of the node being compiled, recorded in the line table by emit
+	pos token.Position
+	// err is the first operand too big for its instruction, found by emit and returned by Compile
+	err *CompileError
+}
+
+func NewCompiler() *Compiler {
+	symbolTable := NewSymbolTable()
+	for i, builtin := range eval.Builtins() {
+		symbolTable.DefineBuiltin(i, builtin.Name)
+	}
+
+	return &Compiler{
+		constants:   []eval.Object{},
+		symbolTable: symbolTable,
+		scopes:      []CompilationScope{{instructions: code.Instructions{}}},
+		scopeIndex:  0,
+	}
+}
+
+// NewCompilerWithState creates a compiler that keeps the globals and constants of a previous compilation,
+// e.g. between the entries of a REPL.
+func NewCompilerWithState(symbolTable *SymbolTable, constants []eval.Object) *Compiler {
+	compiler := NewCompiler()
+	compiler.symbolTable = symbolTable
+	compiler.constants = constants
+	return compiler
+}
+
+// SymbolTable returns the global symbol table, to be passed to NewCompilerWithState.
+func (compiler *Compiler) SymbolTable() *SymbolTable {
+	return compiler.symbolTable
+}
+
+func (compiler *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: compiler.currentInstructions(),
+		Lines:        compiler.scopes[compiler.scopeIndex].lines,
+		Constants:    compiler.constants,
+		Globals:      compiler.symbolTable.GlobalNames(),
+	}
+}
+
+func (compiler *Compiler) Compile(node ast.Node) error {
+	previous := compiler.pos
+	if pos := node.Pos(); pos.Line != 0 {
+		compiler.pos = pos
+	}
+	err := compiler.compileNode(node)
+	compiler.pos = previous
+	if err == nil && compiler.err != nil {
+		return compiler.err
+	}
+	return err
+}
+
+func (compiler *Compiler) compileNode(node ast.Node) error {
+	switch node := node.(type) {
+	// statements
+	case *ast.Program:
+		for _, stmt := range node.Statements {
+			if err := compiler.Compile(stmt); err != nil {
+				return err
+			}
+		}
+	case *ast.ExpressionStatement:
+		if err := compiler.Compile(node.Expression); err != nil {
+			return err
+		}
+		compiler.emit(code.OpPop)
+	case *ast.BlockStatement:
+		for _, stmt := range node.Statements {
+			if err := compiler.Compile(stmt); err != nil {
+				return err
+			}
+		}
+	case *ast.LetStatement:
+		return compiler.compileLetStatement(node)
+	case *ast.ReturnStatement:
+		if err := compiler.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		compiler.emit(code.OpReturnValue)
+
+	// expressions
+	case *ast.PrefixExpression:
+		if err := compiler.Compile(node.Right); err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "!":
+			compiler.emit(code.OpBang)
+		case "-":
+			compiler.emit(code.OpMinus)
+		case "~":
+			compiler.emit(code.OpBitNot)
+		default:
+			return compiler.error("unknown operator %s", node.Operator)
+		}
+	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return compiler.compileLogicalExpression(node)
+		}
+		if err := compiler.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := compiler.Compile(node.Right); err != nil {
+			return err
+		}
+		op, ok := infixOpcodes[node.Operator]
+		if !ok {
+			return compiler.error("unknown operator %s", node.Operator)
+		}
+		compiler.emit(op)
+	case *ast.AssignExpression:
+		return compiler.compileAssignExpression(node)
+	case *ast.IfExpression:
+		return compiler.compileIfExpression(node)
+	case *ast.CallExpression:
+		if err := compiler.Compile(node.Function); err != nil {
+			return err
+		}
+		for _, arg := range node.Arguments {
+			if err := compiler.Compile(arg); err != nil {
+				return err
+			}
+		}
+		compiler.emit(code.OpCall, len(node.Arguments))
+	case *ast.IndexExpression:
+		if err := compiler.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := compiler.Compile(node.Index); err != nil {
+			return err
+		}
+		compiler.emit(code.OpIndex)
+	case *ast.Identifier:
+		symbol, ok := compiler.symbolTable.Resolve(node.Value)
+		if !ok {
+			// like the evaluator, only complain about an unknown identifier when it is used
+			symbol = compiler.symbolTable.DefineGlobal(node.Value)
+		}
+		compiler.loadSymbol(symbol)
+	case *ast.Function:
+		return compiler.compileFunction(node, "")
+	case *ast.Integer:
+		compiler.emit(code.OpConstant, compiler.addConstant(&eval.IntegerObject{Value: node.Value}))
+	case *ast.FloatLiteral:
+		compiler.emit(code.OpConstant, compiler.addConstant(&eval.FloatObject{Value: node.Value}))
+	case *ast.StringLiteral:
+		compiler.emit(code.OpConstant, compiler.addConstant(&eval.StringObject{Value: node.Value}))
+	case *ast.Boolean:
+		if node.Value {
+			compiler.emit(code.OpTrue)
+		} else {
+			compiler.emit(code.OpFalse)
+		}
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			if err := compiler.Compile(element); err != nil {
+				return err
+			}
+		}
+		compiler.emit(code.OpArray, len(node.Elements))
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			if err := compiler.Compile(pair.Key); err != nil {
+				return err
+			}
+			if err := compiler.Compile(pair.Value); err != nil {
+				return err
+			}
+		}
+		compiler.emit(code.OpHash, len(node.Pairs)*2)
+	default:
+		return compiler.error("cannot compile %T", node)
+	}
+	return nil
+}
+
+var infixOpcodes = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	">":  code.OpGreaterThan,
+	"<":  code.OpLessThan,
+	">=": code.OpGreaterEqual,
+	"<=": code.OpLessEqual,
+	"%":  code.OpMod,
+	"**": code.OpPow,
+	"&":  code.OpBitAnd,
+	"|":  code.OpBitOr,
+	"^":  code.OpBitXor,
+	"<<": code.OpShiftLeft,
+	">>": code.OpShiftRight,
+}
+
+func (compiler *Compiler) compileLetStatement(letStmt *ast.LetStatement) error {
+	// a function literal may refer to its own name, anything else sees the previous binding
+	function, isFunction := letStmt.Value.(*ast.Function)
+
+	var symbol Symbol
+	if isFunction {
+		symbol = compiler.symbolTable.Define(letStmt.Name.Value)
+		if err := compiler.compileFunction(function, letStmt.Name.Value); err != nil {
+			return err
+		}
+	} else {
+		if err := compiler.Compile(letStmt.Value); err != nil {
+			return err
+		}
+		symbol = compiler.symbolTable.Define(letStmt.Name.Value)
+	}
+
+	return compiler.storeSymbol(symbol)
+}
+
+// compileAssignExpression compiles an assignment in the order of the evaluator: the target, its current
+// value for a compound operator, then the value assigned. The new value is left on the stack.
+func (compiler *Compiler) compileAssignExpression(assignExpr *ast.AssignExpression) error {
+	op, compound := infixOpcodes[strings.TrimSuffix(assignExpr.Operator, "=")]
+
+	switch target := assignExpr.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := compiler.symbolTable.Resolve(target.Value)
+		if !ok {
+			symbol = compiler.symbolTable.DefineGlobal(target.Value)
+		}
+		if symbol.Scope == BuiltinScope {
+			return compiler.error("cannot assign to builtin: %s", target.Value)
+		}
+		if symbol.Scope == GlobalScope {
+			// a global may not be bound yet, which is only known when the assignment runs
+			compiler.emit(code.OpCheckGlobal, symbol.Index)
+		}
+		if compound {
+			compiler.loadSymbol(symbol)
+		}
+		if err := compiler.compileAssignedValue(assignExpr.Value, op, compound); err != nil {
+			return err
+		}
+		if err := compiler.storeSymbol(symbol); err != nil {
+			return err
+		}
+		compiler.loadSymbol(symbol)
+	case *ast.IndexExpression:
+		if err := compiler.Compile(target.Left); err != nil {
+			return err
+		}
+		if err := compiler.Compile(target.Index); err != nil {
+			return err
+		}
+		if compound {
+			compiler.emit(code.OpPeekIndex)
+		}
+		if err := compiler.compileAssignedValue(assignExpr.Value, op, compound); err != nil {
+			return err
+		}
+		compiler.emit(code.OpSetIndex)
+	default:
+		return compiler.error("cannot assign to %s", assignExpr.Target.String())
+	}
+	return nil
+}
+
+// compileAssignedValue compiles value and for a compound assignment applies op to the current value below it.
+func (compiler *Compiler) compileAssignedValue(value ast.Expression, op code.Opcode, compound bool) error {
+	if err := compiler.Compile(value); err != nil {
+		return err
+	}
+	if compound {
+		compiler.emit(op)
+	}
+	return nil
+}
+
+func (compiler *Compiler) compileIfExpression(ifExpr *ast.IfExpression) error {
+	if err := compiler.Compile(ifExpr.Condition); err != nil {
+		return err
+	}
+
+	// the jump target is patched once the consequence is compiled
+	jumpNotTruthyPos := compiler.emit(code.OpJumpNotTruthy, 9999)
+	if err := compiler.compileBlockValue(ifExpr.Consequence); err != nil {
+		return err
+	}
+
+	jumpPos := compiler.emit(code.OpJump, 9999)
+	compiler.changeOperand(jumpNotTruthyPos, len(compiler.currentInstructions()))
+
+	if ifExpr.Alternative == nil {
+		compiler.emit(code.OpNull)
+	} else if err := compiler.compileBlockValue(ifExpr.Alternative); err != nil {
+		return err
+	}
+	compiler.changeOperand(jumpPos, len(compiler.currentInstructions()))
+	return nil
+}
+
+// compileLogicalExpression compiles && and || so that the right operand is skipped when the left
+// one decides the result. OpBang twice turns the deciding operand into a boolean.
+func (compiler *Compiler) compileLogicalExpression(infixExpr *ast.InfixExpression) error {
+	if err := compiler.Compile(infixExpr.Left); err != nil {
+		return err
+	}
+	jumpNotTruthyPos := compiler.emit(code.OpJumpNotTruthy, 9999)
+
+	if infixExpr.Operator == "&&" {
+		if err := compiler.Compile(infixExpr.Right); err != nil {
+			return err
+		}
+		compiler.emit(code.OpBang)
+		compiler.emit(code.OpBang)
+		jumpPos := compiler.emit(code.OpJump, 9999)
+		compiler.changeOperand(jumpNotTruthyPos, len(compiler.currentInstructions()))
+		compiler.emit(code.OpFalse)
+		compiler.changeOperand(jumpPos, len(compiler.currentInstructions()))
+		return nil
+	}
+
+	compiler.emit(code.OpTrue)
+	jumpPos := compiler.emit(code.OpJump, 9999)
+	compiler.changeOperand(jumpNotTruthyPos, len(compiler.currentInstructions()))
+	if err := compiler.Compile(infixExpr.Right); err != nil {
+		return err
+	}
+	compiler.emit(code.OpBang)
+	compiler.emit(code.OpBang)
+	compiler.changeOperand(jumpPos, len(compiler.currentInstructions()))
+	return nil
+}
+
+// compileBlockValue compiles a block so that it leaves its value on the stack.
+func (compiler *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	if err := compiler.Compile(block); err != nil {
+		return err
+	}
+	if compiler.lastInstructionIs(code.OpPop) {
+		compiler.removeLastPop()
+	} else if !compiler.lastInstructionIs(code.OpReturnValue) {
+		compiler.emit(code.OpNull)
+	}
+	return nil
+}
+
+func (compiler *Compiler) compileFunction(function *ast.Function, name string) error {
+	compiler.enterScope()
+
+	assigned, cells := assignedNames(function)
+	compiler.symbolTable.cells = cells
+	// a function that assigns to its own name refers to the binding, not to itself
+	if name != "" && !assigned[name] {
+		compiler.symbolTable.DefineFunctionName(name)
+	}
+	for _, param := range function.Params {
+		compiler.symbolTable.Define(param.Value)
+	}
+
+	if err := compiler.Compile(function.Body); err != nil {
+		return err
+	}
+	if compiler.lastInstructionIs(code.OpPop) {
+		compiler.replaceLastPopWithReturn()
+	}
+	if !compiler.lastInstructionIs(code.OpReturnValue) {
+		compiler.emit(code.OpReturn)
+	}
+
+	freeSymbols := compiler.symbolTable.FreeSymbols
+	localNames := compiler.symbolTable.LocalNames()
+	lines := compiler.scopes[compiler.scopeIndex].lines
+	instructions := compiler.leaveScope()
+
+	// push the captured values, or their cells, so that OpClosure can collect them
+	for _, symbol := range freeSymbols {
+		compiler.captureSymbol(symbol)
+	}
+
+	compiledFunction := &eval.CompiledFunctionObject{
+		Instructions:  instructions,
+		Lines:         lines,
+		NumLocals:     len(localNames),
+		NumParameters: len(function.Params),
+		Name:          name,
+		LocalNames:    localNames,
+	}
+	compiler.emit(code.OpClosure, compiler.addConstant(compiledFunction), len(freeSymbols))
+	return nil
+}
+
+// assignedNames returns the names assigned anywhere in the body of function and, of those, the ones that a
+// function nested in the body refers to. The locals of the latter are kept in cells, so that the closures and
+// the function see each other's assignments. A let of a parameter or of a name already let counts as an
+// assignment, as it stores into the same slot, and so does a let nested in a block, which may not run:
+// a closure capturing the local before then must find it unbound when it reads it, not when it is created.
+// Shadowing is ignored, which at worst puts a local in a cell needlessly.
+func assignedNames(function *ast.Function) (assigned, cells map[string]bool) {
+	assigned = make(map[string]bool)
+	bound := make(map[string]bool)
+	for _, param := range function.Params {
+		bound[param.Value] = true
+	}
+	unconditional := make(map[ast.Statement]bool)
+	for _, stmt := range function.Body.Statements {
+		unconditional[stmt] = true
+	}
+	ast.Inspect(function.Body, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.AssignExpression:
+			if id, ok := node.Target.(*ast.Identifier); ok {
+				assigned[id.Value] = true
+			}
+		case *ast.LetStatement:
+			if bound[node.Name.Value] || !unconditional[node] {
+				assigned[node.Name.Value] = true
+			}
+			bound[node.Name.Value] = true
+		}
+		return true
+	})
+
+	cells = make(map[string]bool)
+	ast.Inspect(function.Body, func(node ast.Node) bool {
+		nested, ok := node.(*ast.Function)
+		if !ok {
+			return true
+		}
+		ast.Inspect(nested, func(node ast.Node) bool {
+			if id, ok := node.(*ast.Identifier); ok && assigned[id.Value] {
+				cells[id.Value] = true
+			}
+			return true
+		})
+		return false
+	})
+	return assigned, cells
+}
+
+func (compiler *Compiler) loadSymbol(symbol Symbol) {
+	if symbol.Cell {
+		compiler.captureSymbol(symbol)
+		compiler.emit(code.OpGetCell)
+		return
+	}
+	switch symbol.Scope {
+	case GlobalScope:
+		compiler.emit(code.OpGetGlobal, symbol.Index)
+	case LocalScope:
+		compiler.emit(code.OpGetLocal, symbol.Index)
+	case BuiltinScope:
+		compiler.emit(code.OpGetBuiltin, symbol.Index)
+	case FreeScope:
+		compiler.emit(code.OpGetFree, symbol.Index)
+	case FunctionScope:
+		compiler.emit(code.OpCurrentClosure)
+	}
+}
+
+// captureSymbol pushes what a closure captures for symbol: the cell of a cell variable, otherwise its value.
+func (compiler *Compiler) captureSymbol(symbol Symbol) {
+	switch {
+	case symbol.Cell && symbol.Scope == LocalScope:
+		compiler.emit(code.OpLocalCell, symbol.Index)
+	case symbol.Cell && symbol.Scope == FreeScope:
+		compiler.emit(code.OpGetFree, symbol.Index)
+	default:
+		compiler.loadSymbol(symbol)
+	}
+}
+
+// storeSymbol pops the value on the stack into the variable of symbol.
+func (compiler *Compiler) storeSymbol(symbol Symbol) error {
+	switch {
+	case symbol.Cell:
+		compiler.captureSymbol(symbol)
+		compiler.emit(code.OpSetCell)
+	case symbol.Scope == GlobalScope:
+		compiler.emit(code.OpSetGlobal, symbol.Index)
+	case symbol.Scope == LocalScope:
+		compiler.emit(code.OpSetLocal, symbol.Index)
+	default:
+		return compiler.error("cannot assign to %s", symbol.Name)
+	}
+	return nil
+}
+
+func (compiler *Compiler) addConstant(object eval.Object) int {
+	compiler.constants = append(compiler.constants, object)
+	return len(compiler.constants) - 1
+}
+
+// operandNames describe the operands of each instruction, for the error about an operand that does not fit.
+var operandNames = map[code.Opcode][]string{
+	code.OpConstant:      {"constant index"},
+	code.OpClosure:       {"constant index", "free variable count"},
+	code.OpGetGlobal:     {"global index"},
+	code.OpSetGlobal:     {"global index"},
+	code.OpCheckGlobal:   {"global index"},
+	code.OpGetLocal:      {"local index"},
+	code.OpSetLocal:      {"local index"},
+	code.OpLocalCell:     {"local index"},
+	code.OpGetFree:       {"free variable index"},
+	code.OpGetBuiltin:    {"builtin index"},
+	code.OpArray:         {"array length"},
+	code.OpHash:          {"hash length"},
+	code.OpCall:          {"argument count"},
+	code.OpJump:          {"jump target"},
+	code.OpJumpNotTruthy: {"jump target"},
+}
+
+// checkOperands records an error for an operand of op that does not fit in its width, as code.Make
+// would silently truncate it.
+func (compiler *Compiler) checkOperands(op code.Opcode, operands []int) {
+	def, err := code.Lookup(byte(op))
+	if err != nil || compiler.err != nil {
+		return
+	}
+	for i, operand := range operands {
+		if max := code.MaxOperand(def.OperandWidths[i]); operand > max {
+			compiler.err = compiler.error("%s %d exceeds the limit of %d", operandNames[op][i], operand, max)
+			return
+		}
+	}
+}
+
+// emit appends an instruction to the current scope and returns its position.
+func (compiler *Compiler) emit(op code.Opcode, operands ...int) int {
+	compiler.checkOperands(op, operands)
+	instruction := code.Make(op, operands...)
+	pos := compiler.addInstruction(instruction)
+	compiler.setLastInstruction(op, pos)
+	return pos
+}
+
+func (compiler *Compiler) addInstruction(instruction []byte) int {
+	scope := &compiler.scopes[compiler.scopeIndex]
+	pos := len(scope.instructions)
+	scope.instructions = append(scope.instructions, instruction...)
+
+	if n := len(scope.lines); n == 0 || scope.lines[n-1].Pos != compiler.pos {
+		scope.lines = append(scope.lines, code.LineEntry{Offset: pos, Pos: compiler.pos})
+	}
+	return pos
+}
+
+func (compiler *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	scope := &compiler.scopes[compiler.scopeIndex]
+	scope.previousInstruction = scope.lastInstruction
+	scope.lastInstruction = EmittedInstruction{Opcode: op, Position: pos}
+}
+
+func (compiler *Compiler) currentInstructions() code.Instructions {
+	return compiler.scopes[compiler.scopeIndex].instructions
+}
+
+func (compiler *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(compiler.currentInstructions()) == 0 {
+		return false
+	}
+	return compiler.scopes[compiler.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (compiler *Compiler) removeLastPop() {
+	scope := &compiler.scopes[compiler.scopeIndex]
+	pos := scope.lastInstruction.Position
+	scope.instructions = scope.instructions[:pos]
+	for len(scope.lines) > 0 && scope.lines[len(scope.lines)-1].Offset >= pos {
+		scope.lines = scope.lines[:len(scope.lines)-1]
+	}
+	scope.lastInstruction = scope.previousInstruction
+}
+
+func (compiler *Compiler) replaceLastPopWithReturn() {
+	pos := compiler.scopes[compiler.scopeIndex].lastInstruction.Position
+	compiler.replaceInstruction(pos, code.Make(code.OpReturnValue))
+	compiler.scopes[compiler.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (compiler *Compiler) replaceInstruction(pos int, instruction []byte) {
+	ins := compiler.currentInstructions()
+	copy(ins[pos:], instruction)
+}
+
+func (compiler *Compiler) changeOperand(pos int, operand int) {
+	op := code.Opcode(compiler.currentInstructions()[pos])
+	compiler.checkOperands(op, []int{operand})
+	compiler.replaceInstruction(pos, code.Make(op, operand))
+}
+
+func (compiler *Compiler) error(format string, a ...interface{}) *CompileError {
+	return &CompileError{Message: fmt.Sprintf(format, a...), Pos: compiler.pos}
+}
+
+func (compiler *Compiler) enterScope() {
+	compiler.scopes = append(compiler.scopes, CompilationScope{instructions: code.Instructions{}})
+	compiler.scopeIndex++
+	compiler.symbolTable = NewEnclosedSymbolTable(compiler.symbolTable)
+}
+
+func (compiler *Compiler) leaveScope() code.Instructions {
+	instructions := compiler.currentInstructions()
+	compiler.scopes = compiler.scopes[:len(compiler.scopes)-1]
+	compiler.scopeIndex--
+	compiler.symbolTable = compiler.symbolTable.Outer
+	return instructions
+}
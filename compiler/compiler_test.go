@@ -0,0 +1,285 @@
+package compiler
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/eval"
+	"monkey/token"
+	"strings"
+	"testing"
+)
+
+func compile(t *testing.T, input string) *Bytecode {
+	program, errs := ast.NewParser(token.NewLexer(input)).Parse()
+	if len(errs) != 0 {
+		t.Fatalf("%q: parse errors %v", input, errs)
+	}
+	compiler := NewCompiler()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("%q: compile error %s", input, err)
+	}
+	return compiler.Bytecode()
+}
+
+func concat(instructions ...[]byte) code.Instructions {
+	var result code.Instructions
+	for _, ins := range instructions {
+		result = append(result, ins...)
+	}
+	return result
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected code.Instructions
+	}{
+		{"1 + 2", concat(
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpConstant, 1),
+			code.Make(code.OpAdd),
+			code.Make(code.OpPop),
+		)},
+		{"-1 < 2 == !true", concat(
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpMinus),
+			code.Make(code.OpConstant, 1),
+			code.Make(code.OpLessThan),
+			code.Make(code.OpTrue),
+			code.Make(code.OpBang),
+			code.Make(code.OpEqual),
+			code.Make(code.OpPop),
+		)},
+		{"1 <= 2 || false", concat(
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpConstant, 1),
+			code.Make(code.OpLessEqual),
+			code.Make(code.OpJumpNotTruthy, 14),
+			code.Make(code.OpTrue),
+			code.Make(code.OpJump, 17),
+			code.Make(code.OpFalse),
+			code.Make(code.OpBang),
+			code.Make(code.OpBang),
+			code.Make(code.OpPop),
+		)},
+		{"true && 1 >= 2", concat(
+			code.Make(code.OpTrue),
+			code.Make(code.OpJumpNotTruthy, 16),
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpConstant, 1),
+			code.Make(code.OpGreaterEqual),
+			code.Make(code.OpBang),
+			code.Make(code.OpBang),
+			code.Make(code.OpJump, 17),
+			code.Make(code.OpFalse),
+			code.Make(code.OpPop),
+		)},
+		{"if (true) { 10 }; 3333", concat(
+			code.Make(code.OpTrue),
+			code.Make(code.OpJumpNotTruthy, 10),
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpJump, 11),
+			code.Make(code.OpNull),
+			code.Make(code.OpPop),
+			code.Make(code.OpConstant, 1),
+			code.Make(code.OpPop),
+		)},
+		{`let a = [1]; {"k": a[0]}; len(a)`, concat(
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpArray, 1),
+			code.Make(code.OpSetGlobal, 0),
+			code.Make(code.OpConstant, 1),
+			code.Make(code.OpGetGlobal, 0),
+			code.Make(code.OpConstant, 2),
+			code.Make(code.OpIndex),
+			code.Make(code.OpHash, 2),
+			code.Make(code.OpPop),
+			code.Make(code.OpGetBuiltin, 0),
+			code.Make(code.OpGetGlobal, 0),
+			code.Make(code.OpCall, 1),
+			code.Make(code.OpPop),
+		)},
+	}
+
+	for _, tt := range tests {
+		bytecode := compile(t, tt.input)
+		if bytecode.Instructions.String() != tt.expected.String() {
+			t.Errorf("%q: expected\n%s\ngot\n%s", tt.input, tt.expected, bytecode.Instructions)
+		}
+	}
+}
+
+func TestCompileClosures(t *testing.T) {
+	bytecode := compile(t, "let adder = fn(a) { fn(b) { a + b } }; let f = fn() { f() };")
+
+	inner := bytecode.Constants[0].(*eval.CompiledFunctionObject)
+	expectedInner := concat(
+		code.Make(code.OpGetFree, 0),
+		code.Make(code.OpGetLocal, 0),
+		code.Make(code.OpAdd),
+		code.Make(code.OpReturnValue),
+	)
+	if inner.Instructions.String() != expectedInner.String() {
+		t.Errorf("inner: expected\n%s\ngot\n%s", expectedInner, inner.Instructions)
+	}
+
+	outer := bytecode.Constants[1].(*eval.CompiledFunctionObject)
+	expectedOuter := concat(
+		code.Make(code.OpGetLocal, 0),
+		code.Make(code.OpClosure, 0, 1),
+		code.Make(code.OpReturnValue),
+	)
+	if outer.Instructions.String() != expectedOuter.String() || outer.NumLocals != 1 || outer.NumParameters != 1 {
+		t.Errorf("outer: expected\n%s\ngot\n%s", expectedOuter, outer.Instructions)
+	}
+
+	recursive := bytecode.Constants[2].(*eval.CompiledFunctionObject)
+	expectedRecursive := concat(
+		code.Make(code.OpCurrentClosure),
+		code.Make(code.OpCall, 0),
+		code.Make(code.OpReturnValue),
+	)
+	if recursive.Instructions.String() != expectedRecursive.String() || recursive.Name != "f" {
+		t.Errorf("recursive: expected\n%s\ngot\n%s", expectedRecursive, recursive.Instructions)
+	}
+}
+
+func TestCompileAssignments(t *testing.T) {
+	bytecode := compile(t, "let x = 1; x += 2; let a = [x]; a[0] = 3;")
+	expected := concat(
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpSetGlobal, 0),
+		code.Make(code.OpCheckGlobal, 0),
+		code.Make(code.OpGetGlobal, 0),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpAdd),
+		code.Make(code.OpSetGlobal, 0),
+		code.Make(code.OpGetGlobal, 0),
+		code.Make(code.OpPop),
+		code.Make(code.OpGetGlobal, 0),
+		code.Make(code.OpArray, 1),
+		code.Make(code.OpSetGlobal, 1),
+		code.Make(code.OpGetGlobal, 1),
+		code.Make(code.OpConstant, 2),
+		code.Make(code.OpConstant, 3),
+		code.Make(code.OpSetIndex),
+		code.Make(code.OpPop),
+	)
+	if bytecode.Instructions.String() != expected.String() {
+		t.Errorf("expected\n%s\ngot\n%s", expected, bytecode.Instructions)
+	}
+
+	// c is assigned by the closure, so both share it through a cell; d is not and is captured by value
+	bytecode = compile(t, "fn(d) { let c = 0; fn() { c *= d; c } }")
+	inner := bytecode.Constants[1].(*eval.CompiledFunctionObject)
+	expectedInner := concat(
+		code.Make(code.OpGetFree, 0),
+		code.Make(code.OpGetCell),
+		code.Make(code.OpGetFree, 1),
+		code.Make(code.OpMul),
+		code.Make(code.OpGetFree, 0),
+		code.Make(code.OpSetCell),
+		code.Make(code.OpGetFree, 0),
+		code.Make(code.OpGetCell),
+		code.Make(code.OpPop),
+		code.Make(code.OpGetFree, 0),
+		code.Make(code.OpGetCell),
+		code.Make(code.OpReturnValue),
+	)
+	if inner.Instructions.String() != expectedInner.String() {
+		t.Errorf("inner: expected\n%s\ngot\n%s", expectedInner, inner.Instructions)
+	}
+	outer := bytecode.Constants[2].(*eval.CompiledFunctionObject)
+	expectedOuter := concat(
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpLocalCell, 1),
+		code.Make(code.OpSetCell),
+		code.Make(code.OpLocalCell, 1),
+		code.Make(code.OpGetLocal, 0),
+		code.Make(code.OpClosure, 1, 2),
+		code.Make(code.OpReturnValue),
+	)
+	if outer.Instructions.String() != expectedOuter.String() {
+		t.Errorf("outer: expected\n%s\ngot\n%s", expectedOuter, outer.Instructions)
+	}
+}
+
+func TestCompileUnboundGlobals(t *testing.T) {
+	// b is used before it is defined and c never is: both get a global slot that the VM checks when used
+	bytecode := compile(t, "let a = fn() { b() + c }; let b = fn() { 1 };")
+	if strings.Join(bytecode.Globals, ",") != "a,b,c" {
+		t.Errorf("expected globals a,b,c, got %v", bytecode.Globals)
+	}
+	a := bytecode.Constants[0].(*eval.CompiledFunctionObject)
+	expected := concat(
+		code.Make(code.OpGetGlobal, 1),
+		code.Make(code.OpCall, 0),
+		code.Make(code.OpGetGlobal, 2),
+		code.Make(code.OpAdd),
+		code.Make(code.OpReturnValue),
+	)
+	if a.Instructions.String() != expected.String() {
+		t.Errorf("expected\n%s\ngot\n%s", expected, a.Instructions)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"len += 1", "cannot assign to builtin: len"},
+		{"let f = fn() { first = 1 };", "cannot assign to builtin: first"},
+	}
+
+	for _, tt := range tests {
+		program, _ := ast.NewParser(token.NewLexer(tt.input)).Parse()
+		err := NewCompiler().Compile(program)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: unexpected error %v", tt.input, err)
+		}
+	}
+}
+
+// repeat joins n copies of format, each formatted with its index, with sep.
+func repeat(n int, format, sep string) string {
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = fmt.Sprintf(format, i)
+	}
+	return strings.Join(parts, sep)
+}
+
+func TestCompileOperandLimits(t *testing.T) {
+	// nested makes a function whose closure captures outer locals of one function and inner locals of another
+	nested := func(outer, inner int) string {
+		return "fn() { " + repeat(outer, "let a%d = true; ", "") + "fn() { " + repeat(inner, "let b%d = true; ", "") +
+			"fn() { [" + repeat(outer, "a%d", ", ") + ", " + repeat(inner, "b%d", ", ") + "] } } }"
+	}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{repeat(70000, "%d", "; "), "constant index 65536 exceeds the limit of 65535"},
+		{repeat(65537, "let g%d = true", "; "), "global index 65536 exceeds the limit of 65535"},
+		{"fn() { " + repeat(300, "let v%d = true", "; ") + " }", "local index 256 exceeds the limit of 255"},
+		{nested(200, 100), "free variable index 256 exceeds the limit of 255"},
+		{nested(200, 56), "free variable count 256 exceeds the limit of 255"},
+		{"let x = 1; [" + strings.Repeat("x, ", 65535) + "x" + "]", "array length 65536 exceeds the limit of 65535"},
+		{"let x = 1; {" + strings.Repeat("x: x, ", 32767) + "x: x" + "}", "hash length 65536 exceeds the limit of 65535"},
+		{"let x = 1; len(" + strings.Repeat("x, ", 255) + "x" + ")", "argument count 256 exceeds the limit of 255"},
+		{"let x = 1; if (x) { " + strings.Repeat("x; ", 16383) + "x" + " }", "jump target 65550 exceeds the limit of 65535"},
+	}
+
+	for i, tt := range tests {
+		program, errs := ast.NewParser(token.NewLexer(tt.input)).Parse()
+		if len(errs) != 0 {
+			t.Fatalf("test %d: parse errors %v", i, errs)
+		}
+		err := NewCompiler().Compile(program)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("test %d: expected %q, got %v", i, tt.expected, err)
+		}
+	}
+}
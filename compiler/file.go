@@ -0,0 +1,408 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"monkey/code"
+	"monkey/eval"
+	"monkey/token"
+)
+
+// A compiled program is stored in a .mkc file with the following layout. Integers are varints
+// (encoding/binary) unless noted, strings and byte slices are prefixed with their length.
+//
+//	magic        4 bytes "\x7fMKC"
+//	version      uint16, big endian
+//	source       name of the source file, for diagnostics
+//	builtins     count, then the name of every builtin in index order
+//	globals      count, then the name of every global slot in index order
+//	instructions the main program
+//	lines        the line table of the main program
+//	constants    count, then for each a tag byte and its encoding:
+//	               'i' integer: signed varint
+//	               'd' float:   IEEE 754 bits, uint64 big endian
+//	               'b' boolean: one byte, 0 or 1
+//	               's' string:  string
+//	               'f' function: name, number of locals and their names, number of parameters, instructions, lines
+//
+// A line table is a count followed by offset, byte offset, line, column and byte column of every entry.
+
+const (
+	FileMagic   = "\x7fMKC"
+	FileVersion = 2
+)
+
+const (
+	integerTag  = 'i'
+	floatTag    = 'd'
+	booleanTag  = 'b'
+	stringTag   = 's'
+	functionTag = 'f'
+)
+
+var ErrNotBytecode = errors.New("not a Monkey bytecode file")
+
+// IsBytecode reports whether data starts with the .mkc magic header.
+func IsBytecode(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(FileMagic))
+}
+
+// Encode writes bytecode in the .mkc format. source names the file it was compiled from.
+func Encode(w io.Writer, bytecode *Bytecode, source string) error {
+	encoder := &encoder{}
+	encoder.buffer.WriteString(FileMagic)
+	encoder.buffer.Write([]byte{FileVersion >> 8, FileVersion & 0xff})
+	encoder.writeString(source)
+
+	builtins := eval.Builtins()
+	encoder.writeUvarint(uint64(len(builtins)))
+	for _, builtin := range builtins {
+		encoder.writeString(builtin.Name)
+	}
+
+	encoder.writeUvarint(uint64(len(bytecode.Globals)))
+	for _, name := range bytecode.Globals {
+		encoder.writeString(name)
+	}
+
+	encoder.writeBytes(bytecode.Instructions)
+	encoder.writeLines(bytecode.Lines)
+
+	encoder.writeUvarint(uint64(len(bytecode.Constants)))
+	for _, constant := range bytecode.Constants {
+		if err := encoder.writeConstant(constant); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(encoder.buffer.Bytes())
+	return err
+}
+
+// Decode reads a .mkc file, validating its header before anything else, and returns the bytecode
+// with the name of its source file.
+func Decode(r io.Reader) (*Bytecode, string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if !IsBytecode(data) {
+		return nil, "", ErrNotBytecode
+	}
+	decoder := &decoder{data: data[len(FileMagic):]}
+
+	if len(decoder.data) < 2 {
+		return nil, "", fmt.Errorf("truncated bytecode header")
+	}
+	version := binary.BigEndian.Uint16(decoder.data)
+	decoder.data = decoder.data[2:]
+	if version != FileVersion {
+		return nil, "", fmt.Errorf("unsupported bytecode version %d, expected %d", version, FileVersion)
+	}
+	source := decoder.readString()
+
+	// builtins are referenced by index, so they must be registered in the same order as when compiling
+	builtins := eval.Builtins()
+	count := decoder.readCount()
+	for i := 0; i < count && decoder.err == nil; i++ {
+		name := decoder.readString()
+		if i >= len(builtins) || builtins[i].Name != name {
+			return nil, "", fmt.Errorf("bytecode requires builtin %q at index %d", name, i)
+		}
+	}
+
+	bytecode := &Bytecode{}
+	count = decoder.readCount()
+	for i := 0; i < count && decoder.err == nil; i++ {
+		bytecode.Globals = append(bytecode.Globals, decoder.readString())
+	}
+	bytecode.Instructions = decoder.readBytes()
+	bytecode.Lines = decoder.readLines()
+
+	count = decoder.readCount()
+	for i := 0; i < count && decoder.err == nil; i++ {
+		bytecode.Constants = append(bytecode.Constants, decoder.readConstant())
+	}
+
+	if decoder.err != nil {
+		return nil, "", decoder.err
+	}
+	if len(decoder.data) != 0 {
+		return nil, "", fmt.Errorf("%d unexpected bytes after the constant pool", len(decoder.data))
+	}
+
+	// the VM trusts the operands of its instructions, so a file must not be able to make them refer to
+	// what does not exist. How the instructions use the stack is not checked, see vmEngine.RunBytecode.
+	if err := validate(bytecode.Instructions, 0, bytecode.Constants); err != nil {
+		return nil, "", err
+	}
+	for _, constant := range bytecode.Constants {
+		if function, ok := constant.(*eval.CompiledFunctionObject); ok {
+			if err := validate(function.Instructions, function.NumLocals, bytecode.Constants); err != nil {
+				return nil, "", fmt.Errorf("%w in function %s", err, eval.FunctionName(function.Name))
+			}
+		}
+	}
+	return bytecode, source, nil
+}
+
+// validate checks that instructions consist of known opcodes with all their operands, and that the
+// constants, builtins, local slots and jump targets the operands refer to exist. numLocals is the
+// number of local slots of the function the instructions belong to. Free variables are not checked, as
+// their number depends on the closure.
+func validate(instructions code.Instructions, numLocals int, constants []eval.Object) error {
+	for ip := 0; ip < len(instructions); {
+		op := code.Opcode(instructions[ip])
+		def, err := code.Lookup(byte(op))
+		if err != nil {
+			return fmt.Errorf("malformed bytecode: %s at offset %d", err, ip)
+		}
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if ip+1+width > len(instructions) {
+			return fmt.Errorf("malformed bytecode: truncated %s at offset %d", def.Name, ip)
+		}
+		operands, _ := code.ReadOperands(def, instructions[ip+1:])
+
+		var limit int
+		switch op {
+		case code.OpConstant:
+			limit = len(constants)
+		case code.OpClosure:
+			if operands[0] < len(constants) {
+				if _, ok := constants[operands[0]].(*eval.CompiledFunctionObject); !ok {
+					return fmt.Errorf("malformed bytecode: %s at offset %d refers to a %s constant",
+						def.Name, ip, constants[operands[0]].Type())
+				}
+			}
+			limit = len(constants)
+		case code.OpGetBuiltin:
+			limit = len(eval.Builtins())
+		case code.OpGetLocal, code.OpSetLocal, code.OpLocalCell:
+			limit = numLocals
+		case code.OpJump, code.OpJumpNotTruthy:
+			limit = len(instructions) + 1
+		default:
+			limit = -1
+		}
+		if limit >= 0 && operands[0] >= limit {
+			return fmt.Errorf("malformed bytecode: %s operand %d out of range at offset %d", def.Name, operands[0], ip)
+		}
+		ip += 1 + width
+	}
+	return nil
+}
+
+type encoder struct {
+	buffer bytes.Buffer
+}
+
+func (encoder *encoder) writeUvarint(value uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], value)
+	encoder.buffer.Write(buf[:n])
+}
+
+func (encoder *encoder) writeVarint(value int64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], value)
+	encoder.buffer.Write(buf[:n])
+}
+
+func (encoder *encoder) writeBytes(value []byte) {
+	encoder.writeUvarint(uint64(len(value)))
+	encoder.buffer.Write(value)
+}
+
+func (encoder *encoder) writeString(value string) {
+	encoder.writeBytes([]byte(value))
+}
+
+func (encoder *encoder) writeLines(lines code.LineTable) {
+	encoder.writeUvarint(uint64(len(lines)))
+	for _, entry := range lines {
+		encoder.writeUvarint(uint64(entry.Offset))
+		encoder.writeUvarint(uint64(entry.Pos.Offset))
+		encoder.writeUvarint(uint64(entry.Pos.Line))
+		encoder.writeUvarint(uint64(entry.Pos.Column))
+		encoder.writeUvarint(uint64(entry.Pos.ByteColumn))
+	}
+}
+
+func (encoder *encoder) writeConstant(constant eval.Object) error {
+	switch constant := constant.(type) {
+	case *eval.IntegerObject:
+		encoder.buffer.WriteByte(integerTag)
+		encoder.writeVarint(constant.Value)
+	case *eval.FloatObject:
+		encoder.buffer.WriteByte(floatTag)
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], math.Float64bits(constant.Value))
+		encoder.buffer.Write(buf[:])
+	case *eval.BooleanObject:
+		encoder.buffer.WriteByte(booleanTag)
+		if constant.Value {
+			encoder.buffer.WriteByte(1)
+		} else {
+			encoder.buffer.WriteByte(0)
+		}
+	case *eval.StringObject:
+		encoder.buffer.WriteByte(stringTag)
+		encoder.writeString(constant.Value)
+	case *eval.CompiledFunctionObject:
+		encoder.buffer.WriteByte(functionTag)
+		encoder.writeString(constant.Name)
+		encoder.writeUvarint(uint64(constant.NumLocals))
+		for i := 0; i < constant.NumLocals; i++ {
+			name := ""
+			if i < len(constant.LocalNames) {
+				name = constant.LocalNames[i]
+			}
+			encoder.writeString(name)
+		}
+		encoder.writeUvarint(uint64(constant.NumParameters))
+		encoder.writeBytes(constant.Instructions)
+		encoder.writeLines(constant.Lines)
+	default:
+		return fmt.Errorf("cannot encode constant of type %s", constant.Type())
+	}
+	return nil
+}
+
+// decoder reads from data. After the first error every read returns a zero value and err is kept.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (decoder *decoder) fail(format string, a ...interface{}) {
+	if decoder.err == nil {
+		decoder.err = fmt.Errorf(format, a...)
+	}
+}
+
+func (decoder *decoder) readUvarint() uint64 {
+	if decoder.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(decoder.data)
+	if n <= 0 {
+		decoder.fail("malformed bytecode: bad unsigned varint")
+		return 0
+	}
+	decoder.data = decoder.data[n:]
+	return value
+}
+
+func (decoder *decoder) readVarint() int64 {
+	if decoder.err != nil {
+		return 0
+	}
+	value, n := binary.Varint(decoder.data)
+	if n <= 0 {
+		decoder.fail("malformed bytecode: bad varint")
+		return 0
+	}
+	decoder.data = decoder.data[n:]
+	return value
+}
+
+// readCount reads a length and checks that it is not larger than the remaining data.
+func (decoder *decoder) readCount() int {
+	count := decoder.readUvarint()
+	if count > uint64(len(decoder.data)) {
+		decoder.fail("malformed bytecode: length %d exceeds remaining %d bytes", count, len(decoder.data))
+		return 0
+	}
+	return int(count)
+}
+
+func (decoder *decoder) readByte() byte {
+	if decoder.err != nil {
+		return 0
+	}
+	if len(decoder.data) == 0 {
+		decoder.fail("malformed bytecode: unexpected end of file")
+		return 0
+	}
+	value := decoder.data[0]
+	decoder.data = decoder.data[1:]
+	return value
+}
+
+func (decoder *decoder) readBytes() []byte {
+	length := decoder.readCount()
+	if decoder.err != nil {
+		return nil
+	}
+	value := make([]byte, length)
+	copy(value, decoder.data)
+	decoder.data = decoder.data[length:]
+	return value
+}
+
+func (decoder *decoder) readString() string {
+	return string(decoder.readBytes())
+}
+
+func (decoder *decoder) readLines() code.LineTable {
+	count := decoder.readCount()
+	lines := make(code.LineTable, 0, count)
+	for i := 0; i < count && decoder.err == nil; i++ {
+		entry := code.LineEntry{Offset: int(decoder.readUvarint())}
+		entry.Pos = token.Position{
+			Offset:     int(decoder.readUvarint()),
+			Line:       int(decoder.readUvarint()),
+			Column:     int(decoder.readUvarint()),
+			ByteColumn: int(decoder.readUvarint()),
+		}
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func (decoder *decoder) readConstant() eval.Object {
+	switch tag := decoder.readByte(); tag {
+	case integerTag:
+		return &eval.IntegerObject{Value: decoder.readVarint()}
+	case floatTag:
+		if len(decoder.data) < 8 {
+			decoder.fail("malformed bytecode: unexpected end of file")
+			return nil
+		}
+		value := math.Float64frombits(binary.BigEndian.Uint64(decoder.data))
+		decoder.data = decoder.data[8:]
+		return &eval.FloatObject{Value: value}
+	case booleanTag:
+		if decoder.readByte() == 1 {
+			return eval.TRUE
+		}
+		return eval.FALSE
+	case stringTag:
+		return &eval.StringObject{Value: decoder.readString()}
+	case functionTag:
+		function := &eval.CompiledFunctionObject{Name: decoder.readString()}
+		function.NumLocals = decoder.readCount()
+		for i := 0; i < function.NumLocals && decoder.err == nil; i++ {
+			function.LocalNames = append(function.LocalNames, decoder.readString())
+		}
+		if parameters := decoder.readUvarint(); parameters <= uint64(function.NumLocals) {
+			function.NumParameters = int(parameters)
+		} else {
+			decoder.fail("malformed bytecode: function %s has %d parameters but %d locals",
+				eval.FunctionName(function.Name), parameters, function.NumLocals)
+		}
+		function.Instructions = decoder.readBytes()
+		function.Lines = decoder.readLines()
+		return function
+	default:
+		decoder.fail("malformed bytecode: unknown constant tag %q", tag)
+		return nil
+	}
+}
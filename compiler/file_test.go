@@ -0,0 +1,107 @@
+package compiler
+
+import (
+	"bytes"
+	"monkey/code"
+	"monkey/eval"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	bytecode := compile(t, `let greet = fn(name) { "hi " + name }; greet("you"); -42; true`)
+	bytecode.Constants = append(bytecode.Constants, eval.TRUE, &eval.FloatObject{Value: -1.5e-7})
+
+	var buffer bytes.Buffer
+	if err := Encode(&buffer, bytecode, "greet.mk"); err != nil {
+		t.Fatal(err)
+	}
+	if !IsBytecode(buffer.Bytes()) {
+		t.Fatalf("missing magic header")
+	}
+
+	decoded, source, err := Decode(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source != "greet.mk" {
+		t.Errorf("expected source greet.mk, got %s", source)
+	}
+	if strings.Join(decoded.Globals, ",") != "greet" {
+		t.Errorf("expected globals [greet], got %v", decoded.Globals)
+	}
+	if decoded.Instructions.String() != bytecode.Instructions.String() {
+		t.Errorf("instructions differ:\n%s\n%s", bytecode.Instructions, decoded.Instructions)
+	}
+	if len(decoded.Lines) != len(bytecode.Lines) || decoded.Lines[len(decoded.Lines)-1] != bytecode.Lines[len(bytecode.Lines)-1] {
+		t.Errorf("lines differ: %v %v", bytecode.Lines, decoded.Lines)
+	}
+	if len(decoded.Constants) != len(bytecode.Constants) {
+		t.Fatalf("expected %d constants, got %d", len(bytecode.Constants), len(decoded.Constants))
+	}
+	for i, constant := range bytecode.Constants {
+		if function, ok := constant.(*eval.CompiledFunctionObject); ok {
+			other := decoded.Constants[i].(*eval.CompiledFunctionObject)
+			if other.Name != function.Name || other.NumLocals != function.NumLocals ||
+				other.NumParameters != function.NumParameters || other.Instructions.String() != function.Instructions.String() ||
+				strings.Join(other.LocalNames, ",") != strings.Join(function.LocalNames, ",") {
+				t.Errorf("function constant %d differs", i)
+			}
+		} else if decoded.Constants[i].Inspect() != constant.Inspect() {
+			t.Errorf("constant %d: expected %s, got %s", i, constant.Inspect(), decoded.Constants[i].Inspect())
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := Encode(&buffer, compile(t, "1 + 2"), "a.mk"); err != nil {
+		t.Fatal(err)
+	}
+	valid := buffer.Bytes()
+
+	encode := func(bytecode *Bytecode) []byte {
+		var buffer bytes.Buffer
+		if err := Encode(&buffer, bytecode, "a.mk"); err != nil {
+			t.Fatal(err)
+		}
+		return buffer.Bytes()
+	}
+	// crafted encodes main instructions, which the compiler would never produce, along with a
+	// function constant holding function and a boolean constant
+	crafted := func(main []byte, function ...[]byte) []byte {
+		return encode(&Bytecode{Instructions: main, Constants: []eval.Object{
+			&eval.CompiledFunctionObject{Name: "f", Instructions: concat(function...), NumLocals: 1},
+			eval.TRUE,
+		}})
+	}
+
+	tests := []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte("let a = 1;"), "not a Monkey bytecode file"},
+		{[]byte(FileMagic + "\x00"), "truncated bytecode header"},
+		{append([]byte(FileMagic+"\x00\x01"), valid[6:]...), "unsupported bytecode version 1, expected 2"},
+		{valid[:len(valid)-1], "malformed bytecode"},
+		{append(append([]byte{}, valid...), 0), "1 unexpected bytes after the constant pool"},
+		{crafted([]byte{255}), "malformed bytecode: opcode 255 undefined at offset 0"},
+		{crafted(concat(code.Make(code.OpPop), code.Make(code.OpConstant, 1)[:2])), "malformed bytecode: truncated OpConstant at offset 1"},
+		{crafted(code.Make(code.OpConstant, 2)), "malformed bytecode: OpConstant operand 2 out of range at offset 0"},
+		{crafted(code.Make(code.OpClosure, 1, 0)), "malformed bytecode: OpClosure at offset 0 refers to a BOOLEAN constant"},
+		{crafted(code.Make(code.OpGetBuiltin, 200)), "malformed bytecode: OpGetBuiltin operand 200 out of range at offset 0"},
+		{crafted(code.Make(code.OpJump, 4)), "malformed bytecode: OpJump operand 4 out of range at offset 0"},
+		{crafted(code.Make(code.OpGetLocal, 0)), "malformed bytecode: OpGetLocal operand 0 out of range at offset 0"},
+		{encode(&Bytecode{Constants: []eval.Object{&eval.CompiledFunctionObject{NumLocals: 1, NumParameters: 2}}}),
+			"malformed bytecode: function <anonymous> has 2 parameters but 1 locals"},
+		{crafted(nil, code.Make(code.OpGetLocal, 0), code.Make(code.OpSetLocal, 1)),
+			"malformed bytecode: OpSetLocal operand 1 out of range at offset 2 in function f"},
+	}
+
+	for _, tt := range tests {
+		_, _, err := Decode(bytes.NewReader(tt.data))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.expected) {
+			t.Errorf("expected error %q, got %v", tt.expected, err)
+		}
+	}
+}
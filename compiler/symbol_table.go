@@ -0,0 +1,149 @@
+package compiler
+
+type SymbolScope = string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+
+	// Cell is set for a local, and the free variables capturing it, whose slot holds a cell that the
+	// closures share instead of the value itself
+	Cell bool
+}
+
+// SymbolTable resolves identifiers to global, local, builtin or free variable slots.
+// Each function body gets its own table whose outer is the table of the enclosing scope.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store          map[string]Symbol
+	numDefinitions int
+
+	// FreeSymbols are the outer symbols captured by the function this table belongs to, in capture order.
+	FreeSymbols []Symbol
+
+	// cells are the names whose locals are defined as cells
+	cells map[string]bool
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{store: make(map[string]Symbol)}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	table := NewSymbolTable()
+	table.Outer = outer
+	return table
+}
+
+// Define allocates a new slot for name, or reuses the slot of an existing binding in the same scope.
+func (table *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := table.store[name]; ok && (symbol.Scope == GlobalScope || symbol.Scope == LocalScope) {
+		return symbol
+	}
+
+	symbol := Symbol{Name: name, Index: table.numDefinitions}
+	if table.Outer == nil {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+		symbol.Cell = table.cells[name]
+	}
+	table.store[name] = symbol
+	table.numDefinitions++
+	return symbol
+}
+
+// DefineGlobal allocates a global slot for name in the outermost table, or returns the existing one. The
+// compiler uses it for identifiers it cannot resolve: they may be defined later, e.g. by a function
+// declared after its caller, and the VM reports them only if they are still unbound when used.
+func (table *SymbolTable) DefineGlobal(name string) Symbol {
+	for table.Outer != nil {
+		table = table.Outer
+	}
+	return table.Define(name)
+}
+
+// GlobalNames returns the names of the global slots of the outermost table, indexed by slot.
+func (table *SymbolTable) GlobalNames() []string {
+	for table.Outer != nil {
+		table = table.Outer
+	}
+	names := make([]string, table.numDefinitions)
+	for name, symbol := range table.store {
+		if symbol.Scope == GlobalScope {
+			names[symbol.Index] = name
+		}
+	}
+	return names
+}
+
+// LocalNames returns the names of the local slots of the table in index order.
+func (table *SymbolTable) LocalNames() []string {
+	names := make([]string, table.numDefinitions)
+	for name, symbol := range table.store {
+		if symbol.Scope == LocalScope {
+			names[symbol.Index] = name
+		}
+	}
+	return names
+}
+
+// Clone returns a copy of the table that can be defined into without changing table, e.g. to discard
+// the definitions of a program that fails to compile.
+func (table *SymbolTable) Clone() *SymbolTable {
+	clone := &SymbolTable{
+		Outer:          table.Outer,
+		store:          make(map[string]Symbol, len(table.store)),
+		numDefinitions: table.numDefinitions,
+		FreeSymbols:    append([]Symbol(nil), table.FreeSymbols...),
+		cells:          table.cells,
+	}
+	for name, symbol := range table.store {
+		clone.store[name] = symbol
+	}
+	return clone
+}
+
+func (table *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Scope: BuiltinScope, Index: index}
+	table.store[name] = symbol
+	return symbol
+}
+
+// DefineFunctionName binds the name of the function being compiled so that it can call itself.
+func (table *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Scope: FunctionScope, Index: 0}
+	table.store[name] = symbol
+	return symbol
+}
+
+func (table *SymbolTable) Resolve(name string) (Symbol, bool) {
+	symbol, ok := table.store[name]
+	if ok || table.Outer == nil {
+		return symbol, ok
+	}
+
+	symbol, ok = table.Outer.Resolve(name)
+	if !ok || symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope {
+		return symbol, ok
+	}
+	return table.defineFree(symbol), true
+}
+
+func (table *SymbolTable) defineFree(original Symbol) Symbol {
+	table.FreeSymbols = append(table.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Scope: FreeScope, Index: len(table.FreeSymbols) - 1, Cell: original.Cell}
+	table.store[original.Name] = symbol
+	return symbol
+}
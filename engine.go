@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/eval"
+	"monkey/vm"
+)
+
+// engine executes programs one after another in the same global state.
+// Runtime and compile errors are returned as *eval.ErrorObject.
+type engine interface {
+	Run(program *ast.Program) eval.Object
+}
+
+func newEngine(name string, compare bool, report io.Writer) (engine, error) {
+	if compare {
+		return &compareEngine{eval: newEvalEngine(), vm: newVMEngine(), report: report}, nil
+	}
+	switch name {
+	case "eval":
+		return newEvalEngine(), nil
+	case "vm":
+		return newVMEngine(), nil
+	default:
+		return nil, fmt.Errorf("unknown engine %q, expected eval or vm", name)
+	}
+}
+
+type evalEngine struct {
+	env *eval.Environment
+}
+
+func newEvalEngine() *evalEngine {
+	return &evalEngine{env: eval.NewEnvironment()}
+}
+
+func (engine *evalEngine) Run(program *ast.Program) eval.Object {
+	return eval.Eval(program, engine.env)
+}
+
+type vmEngine struct {
+	symbolTable *compiler.SymbolTable
+	constants   []eval.Object
+	globals     []eval.Object
+}
+
+func newVMEngine() *vmEngine {
+	return &vmEngine{
+		symbolTable: compiler.NewCompiler().SymbolTable(),
+		constants:   []eval.Object{},
+		globals:     vm.NewGlobalsStore(),
+	}
+}
+
+func (engine *vmEngine) Run(program *ast.Program) eval.Object {
+	// the globals a program defines are kept only if it compiles, they would be unbound otherwise
+	symbolTable := engine.symbolTable.Clone()
+	comp := compiler.NewCompilerWithState(symbolTable, engine.constants)
+	if err := comp.Compile(program); err != nil {
+		if compileErr, ok := err.(*compiler.CompileError); ok {
+			return &eval.ErrorObject{Message: compileErr.Message, Pos: compileErr.Pos}
+		}
+		return &eval.ErrorObject{Message: err.Error()}
+	}
+	bytecode := comp.Bytecode()
+	engine.symbolTable = symbolTable
+	engine.constants = bytecode.Constants
+	return engine.RunBytecode(bytecode)
+}
+
+func (engine *vmEngine) RunBytecode(bytecode *compiler.Bytecode) (result eval.Object) {
+	// decoding a .mkc file does not check how its instructions use the stack, so a crafted file may
+	// still make the VM fail, which is then reported as an error instead of crashing
+	defer func() {
+		if r := recover(); r != nil {
+			result = &eval.ErrorObject{Message: fmt.Sprintf("invalid bytecode: %v", r)}
+		}
+	}()
+
+	machine := vm.NewWithGlobalsStore(bytecode, engine.globals)
+	if err := machine.Run(); err != nil {
+		if errObject, ok := err.(*eval.ErrorObject); ok {
+			return errObject
+		}
+		return &eval.ErrorObject{Message: err.Error()}
+	}
+	return machine.Result()
+}
+
+// compareEngine runs every program on both engines and reports where their results,
+// error messages or output differ. The result of the tree walker is returned.
+type compareEngine struct {
+	eval     *evalEngine
+	vm       *vmEngine
+	report   io.Writer
+	diverged bool
+}
+
+func (engine *compareEngine) Run(program *ast.Program) eval.Object {
+	output := eval.Output
+	defer func() { eval.Output = output }()
+
+	var evalOutput, vmOutput bytes.Buffer
+	eval.Output = &evalOutput
+	evalResult := engine.eval.Run(program)
+	eval.Output = &vmOutput
+	vmResult := engine.vm.Run(program)
+
+	output.Write(evalOutput.Bytes())
+
+	if describe(evalResult) != describe(vmResult) {
+		engine.diverge("eval returned %s, vm returned %s", describe(evalResult), describe(vmResult))
+	}
+	if evalOutput.String() != vmOutput.String() {
+		engine.diverge("eval printed %q, vm printed %q", evalOutput.String(), vmOutput.String())
+	}
+	return evalResult
+}
+
+func (engine *compareEngine) diverge(format string, a ...interface{}) {
+	engine.diverged = true
+	fmt.Fprintf(engine.report, "divergence: "+format+"\n", a...)
+}
+
+// describe renders a result for comparison. Functions are compared by type only
+// since each engine has its own representation of them.
+func describe(result eval.Object) string {
+	switch result := result.(type) {
+	case nil:
+		return "nothing"
+	case *eval.FunctionObject, *eval.ClosureObject:
+		return result.Type()
+	default:
+		return result.Inspect()
+	}
+}
@@ -0,0 +1,134 @@
+package eval
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+// Output is where puts writes to.
+var Output io.Writer = os.Stdout
+
+// builtins is ordered so that a builtin keeps its index once registered.
+var builtins = []*BuiltinObject{
+	{Name: "len", Fn: builtinLen},
+	{Name: "first", Fn: builtinFirst},
+	{Name: "last", Fn: builtinLast},
+	{Name: "rest", Fn: builtinRest},
+	{Name: "push", Fn: builtinPush},
+	{Name: "puts", Fn: builtinPuts},
+}
+
+// RegisterBuiltin makes fn callable from Monkey code as name, replacing any builtin with the same name.
+// Identifiers bound in the environment take precedence over builtins.
+// Returning nil from fn is the same as returning NULL.
+func RegisterBuiltin(name string, fn BuiltinFunction) {
+	for _, builtin := range builtins {
+		if builtin.Name == name {
+			builtin.Fn = fn
+			return
+		}
+	}
+	builtins = append(builtins, &BuiltinObject{Name: name, Fn: fn})
+}
+
+// Builtins returns the registered builtins. A builtin's index in the result never changes.
+func Builtins() []*BuiltinObject {
+	return builtins
+}
+
+func LookupBuiltin(name string) (*BuiltinObject, bool) {
+	for _, builtin := range builtins {
+		if builtin.Name == name {
+			return builtin, true
+		}
+	}
+	return nil, false
+}
+
+func builtinLen(args ...Object) Object {
+	if len(args) != 1 {
+		return wrongNumberOfArguments(1, len(args))
+	}
+	switch arg := args[0].(type) {
+	case *StringObject:
+		return &IntegerObject{Value: int64(utf8.RuneCountInString(arg.Value))}
+	case *ArrayObject:
+		return &IntegerObject{Value: int64(len(arg.Elements))}
+	case *HashObject:
+		return &IntegerObject{Value: int64(arg.Len())}
+	default:
+		return NewError("argument to `len` not supported, got %s", arg.Type())
+	}
+}
+
+func builtinFirst(args ...Object) Object {
+	array, err := arrayArgument("first", args)
+	if err != nil {
+		return err
+	}
+	if len(array.Elements) == 0 {
+		return NULL
+	}
+	return array.Elements[0]
+}
+
+func builtinLast(args ...Object) Object {
+	array, err := arrayArgument("last", args)
+	if err != nil {
+		return err
+	}
+	if len(array.Elements) == 0 {
+		return NULL
+	}
+	return array.Elements[len(array.Elements)-1]
+}
+
+func builtinRest(args ...Object) Object {
+	array, err := arrayArgument("rest", args)
+	if err != nil {
+		return err
+	}
+	if len(array.Elements) == 0 {
+		return NULL
+	}
+	elements := make([]Object, len(array.Elements)-1)
+	copy(elements, array.Elements[1:])
+	return &ArrayObject{Elements: elements}
+}
+
+func builtinPush(args ...Object) Object {
+	if len(args) != 2 {
+		return wrongNumberOfArguments(2, len(args))
+	}
+	array, ok := args[0].(*ArrayObject)
+	if !ok {
+		return NewError("argument to `push` must be ARRAY, got %s", args[0].Type())
+	}
+	elements := make([]Object, len(array.Elements), len(array.Elements)+1)
+	copy(elements, array.Elements)
+	return &ArrayObject{Elements: append(elements, args[1])}
+}
+
+func builtinPuts(args ...Object) Object {
+	for _, arg := range args {
+		fmt.Fprintln(Output, arg.Inspect())
+	}
+	return NULL
+}
+
+func arrayArgument(name string, args []Object) (*ArrayObject, *ErrorObject) {
+	if len(args) != 1 {
+		return nil, wrongNumberOfArguments(1, len(args))
+	}
+	array, ok := args[0].(*ArrayObject)
+	if !ok {
+		return nil, NewError("argument to `%s` must be ARRAY, got %s", name, args[0].Type())
+	}
+	return array, nil
+}
+
+func wrongNumberOfArguments(want, got int) *ErrorObject {
+	return NewError("wrong number of arguments: want=%d, got=%d", want, got)
+}
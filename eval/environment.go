@@ -0,0 +1,47 @@
+package eval
+
+// Environment stores the bindings created by let statements and function parameters.
+// Lookups that miss in the current scope continue in the enclosing one.
+type Environment struct {
+	store map[string]Object
+	outer *Environment
+
+	// depth is the number of function calls in progress in the environment of a call, so that every
+	// evaluation keeps its own count
+	depth int
+}
+
+func NewEnvironment() *Environment {
+	return &Environment{store: make(map[string]Object)}
+}
+
+// NewEnclosedEnvironment creates a scope nested in outer, e.g. for a function call.
+func NewEnclosedEnvironment(outer *Environment) *Environment {
+	env := NewEnvironment()
+	env.outer = outer
+	return env
+}
+
+func (env *Environment) Get(name string) (Object, bool) {
+	value, ok := env.store[name]
+	if !ok && env.outer != nil {
+		return env.outer.Get(name)
+	}
+	return value, ok
+}
+
+func (env *Environment) Set(name string, value Object) Object {
+	env.store[name] = value
+	return value
+}
+
+// Assign replaces the value of the nearest existing binding of name. It reports false if there is none.
+func (env *Environment) Assign(name string, value Object) bool {
+	for scope := env; scope != nil; scope = scope.outer {
+		if _, ok := scope.store[name]; ok {
+			scope.store[name] = value
+			return true
+		}
+	}
+	return false
+}
@@ -1,8 +1,598 @@
 package eval
 
-// func Eval(node ast.Node) Object {
-//     switch node := node.(type) {
-//     case *ast.Integer:
-//         return &IntegerObject{Value: node.Value}
-//     }
-// }
+import (
+	"fmt"
+	"math"
+	"monkey/ast"
+	"monkey/token"
+)
+
+var (
+	NULL  = &NullObject{}
+	TRUE  = &BooleanObject{Value: true}
+	FALSE = &BooleanObject{Value: false}
+)
+
+func Eval(node ast.Node, env *Environment) Object {
+	result := evalNode(node, env)
+
+	// the innermost node that fails gives the error its position
+	if err, ok := result.(*ErrorObject); ok && err.Pos.Line == 0 {
+		err.Pos = node.Pos()
+	}
+	return result
+}
+
+func evalNode(node ast.Node, env *Environment) Object {
+	switch node := node.(type) {
+	// statements
+	case *ast.Program:
+		return evalProgram(node, env)
+	case *ast.LetStatement:
+		value := Eval(node.Value, env)
+		if isError(value) {
+			return value
+		}
+		// like the compiler, name a function after the let statement that defines it
+		if function, ok := value.(*FunctionObject); ok && function.Name == "" {
+			if _, isLiteral := node.Value.(*ast.Function); isLiteral {
+				function.Name = node.Name.Value
+			}
+		}
+		env.Set(node.Name.Value, value)
+		return nil
+	case *ast.ReturnStatement:
+		value := Eval(node.ReturnValue, env)
+		if isError(value) {
+			return value
+		}
+		return &ReturnValueObject{Value: value}
+	case *ast.BlockStatement:
+		return evalBlockStatement(node, env)
+	case *ast.ExpressionStatement:
+		return Eval(node.Expression, env)
+
+	// expressions
+	case *ast.PrefixExpression:
+		right := Eval(node.Right, env)
+		if isError(right) {
+			return right
+		}
+		return evalPrefixExpression(node.Operator, right)
+	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
+		left := Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		right := Eval(node.Right, env)
+		if isError(right) {
+			return right
+		}
+		return evalInfixExpression(node.Operator, left, right)
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
+	case *ast.IfExpression:
+		return evalIfExpression(node, env)
+	case *ast.CallExpression:
+		if id, ok := node.Function.(*ast.Identifier); ok && id.Value == "quote" {
+			if len(node.Arguments) != 1 {
+				return wrongNumberOfArguments(1, len(node.Arguments))
+			}
+			return quote(node.Arguments[0], env)
+		}
+		function := Eval(node.Function, env)
+		if isError(function) {
+			return function
+		}
+		args := evalExpressions(node.Arguments, env)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0]
+		}
+		return applyFunction(function, args, env, node.Pos())
+	case *ast.Identifier:
+		return evalIdentifier(node, env)
+	case *ast.Integer:
+		return &IntegerObject{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &FloatObject{Value: node.Value}
+	case *ast.StringLiteral:
+		return &StringObject{Value: node.Value}
+	case *ast.Boolean:
+		return nativeBoolToBooleanObject(node.Value)
+	case *ast.ArrayLiteral:
+		elements := evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isError(elements[0]) {
+			return elements[0]
+		}
+		return &ArrayObject{Elements: elements}
+	case *ast.HashLiteral:
+		return evalHashLiteral(node, env)
+	case *ast.IndexExpression:
+		left := Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := Eval(node.Index, env)
+		if isError(index) {
+			return index
+		}
+		return evalIndexExpression(left, index)
+	case *ast.MacroLiteral:
+		return NewError("macros can only be defined by a top-level let statement")
+	case *ast.Function:
+		return &FunctionObject{Params: node.Params, Body: node.Body, Env: env}
+	}
+	return nil
+}
+
+func evalProgram(program *ast.Program, env *Environment) Object {
+	var result Object
+	for _, stmt := range program.Statements {
+		result = Eval(stmt, env)
+
+		switch result := result.(type) {
+		case *ReturnValueObject:
+			return result.Value
+		case *ErrorObject:
+			return result
+		}
+	}
+	return result
+}
+
+func evalBlockStatement(block *ast.BlockStatement, env *Environment) Object {
+	var result Object
+	for _, stmt := range block.Statements {
+		result = Eval(stmt, env)
+
+		// keep the wrapper so that the enclosing function or program can unwrap it
+		if result != nil && (result.Type() == ReturnValueType || result.Type() == ErrorType) {
+			return result
+		}
+	}
+	return result
+}
+
+func evalPrefixExpression(operator string, right Object) Object {
+	switch operator {
+	case "!":
+		return nativeBoolToBooleanObject(!isTruthy(right))
+	case "-":
+		switch right := right.(type) {
+		case *IntegerObject:
+			return &IntegerObject{Value: -right.Value}
+		case *FloatObject:
+			return &FloatObject{Value: -right.Value}
+		default:
+			return NewError("unknown operator: -%s", right.Type())
+		}
+	case "~":
+		if right, ok := right.(*IntegerObject); ok {
+			return &IntegerObject{Value: ^right.Value}
+		}
+		return NewError("unknown operator: ~%s", right.Type())
+	default:
+		return NewError("unknown operator: %s%s", operator, right.Type())
+	}
+}
+
+func evalInfixExpression(operator string, left, right Object) Object {
+	switch {
+	case left.Type() == IntegerType && right.Type() == IntegerType:
+		return evalIntegerInfixExpression(operator, left.(*IntegerObject), right.(*IntegerObject))
+	case isNumber(left) && isNumber(right):
+		// an integer operand is promoted when the other one is a float
+		return evalFloatInfixExpression(operator, toFloat(left), toFloat(right), left, right)
+	case left.Type() == StringType && right.Type() == StringType:
+		return evalStringInfixExpression(operator, left.(*StringObject), right.(*StringObject))
+	case left.Type() != right.Type():
+		return NewError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	case operator == "==":
+		return nativeBoolToBooleanObject(left == right)
+	case operator == "!=":
+		return nativeBoolToBooleanObject(left != right)
+	default:
+		return NewError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func evalIntegerInfixExpression(operator string, left, right *IntegerObject) Object {
+	switch operator {
+	case "+":
+		return &IntegerObject{Value: left.Value + right.Value}
+	case "-":
+		return &IntegerObject{Value: left.Value - right.Value}
+	case "*":
+		return &IntegerObject{Value: left.Value * right.Value}
+	case "/":
+		if right.Value == 0 {
+			return NewError("division by zero")
+		}
+		return &IntegerObject{Value: left.Value / right.Value}
+	case "%":
+		if right.Value == 0 {
+			return NewError("modulo by zero")
+		}
+		return &IntegerObject{Value: left.Value % right.Value}
+	case "**":
+		if right.Value < 0 {
+			return NewError("negative exponent: %d", right.Value)
+		}
+		return &IntegerObject{Value: integerPower(left.Value, right.Value)}
+	case "&":
+		return &IntegerObject{Value: left.Value & right.Value}
+	case "|":
+		return &IntegerObject{Value: left.Value | right.Value}
+	case "^":
+		return &IntegerObject{Value: left.Value ^ right.Value}
+	case "<<":
+		if right.Value < 0 {
+			return NewError("negative shift count: %d", right.Value)
+		}
+		return &IntegerObject{Value: left.Value << right.Value}
+	case ">>":
+		if right.Value < 0 {
+			return NewError("negative shift count: %d", right.Value)
+		}
+		return &IntegerObject{Value: left.Value >> right.Value}
+	case "<":
+		return nativeBoolToBooleanObject(left.Value < right.Value)
+	case ">":
+		return nativeBoolToBooleanObject(left.Value > right.Value)
+	case "<=":
+		return nativeBoolToBooleanObject(left.Value <= right.Value)
+	case ">=":
+		return nativeBoolToBooleanObject(left.Value >= right.Value)
+	case "==":
+		return nativeBoolToBooleanObject(left.Value == right.Value)
+	case "!=":
+		return nativeBoolToBooleanObject(left.Value != right.Value)
+	default:
+		return NewError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func evalFloatInfixExpression(operator string, left, right float64, leftObject, rightObject Object) Object {
+	switch operator {
+	case "+":
+		return &FloatObject{Value: left + right}
+	case "-":
+		return &FloatObject{Value: left - right}
+	case "*":
+		return &FloatObject{Value: left * right}
+	case "/":
+		if right == 0 {
+			return NewError("division by zero")
+		}
+		return &FloatObject{Value: left / right}
+	case "%":
+		if right == 0 {
+			return NewError("modulo by zero")
+		}
+		return &FloatObject{Value: math.Mod(left, right)}
+	case "**":
+		return &FloatObject{Value: math.Pow(left, right)}
+	case "<":
+		return nativeBoolToBooleanObject(left < right)
+	case ">":
+		return nativeBoolToBooleanObject(left > right)
+	case "<=":
+		return nativeBoolToBooleanObject(left <= right)
+	case ">=":
+		return nativeBoolToBooleanObject(left >= right)
+	case "==":
+		return nativeBoolToBooleanObject(left == right)
+	case "!=":
+		return nativeBoolToBooleanObject(left != right)
+	default:
+		return NewError("unknown operator: %s %s %s", leftObject.Type(), operator, rightObject.Type())
+	}
+}
+
+// integerPower raises base to a non-negative exponent by squaring. Like the other integer operators it
+// wraps around on overflow.
+func integerPower(base, exponent int64) int64 {
+	result := int64(1)
+	for exponent > 0 {
+		if exponent&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exponent >>= 1
+	}
+	return result
+}
+
+func isNumber(object Object) bool {
+	return object.Type() == IntegerType || object.Type() == FloatType
+}
+
+func toFloat(number Object) float64 {
+	if integer, ok := number.(*IntegerObject); ok {
+		return float64(integer.Value)
+	}
+	return number.(*FloatObject).Value
+}
+
+func evalStringInfixExpression(operator string, left, right *StringObject) Object {
+	switch operator {
+	case "+":
+		return &StringObject{Value: left.Value + right.Value}
+	case "==":
+		return nativeBoolToBooleanObject(left.Value == right.Value)
+	case "!=":
+		return nativeBoolToBooleanObject(left.Value != right.Value)
+	default:
+		return NewError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// evalLogicalExpression evaluates && and ||. The right operand is only evaluated if the left one
+// does not decide the result, which is always a boolean.
+func evalLogicalExpression(node *ast.InfixExpression, env *Environment) Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+	if isTruthy(left) == (node.Operator == "||") {
+		return nativeBoolToBooleanObject(isTruthy(left))
+	}
+
+	right := Eval(node.Right, env)
+	if isError(right) {
+		return right
+	}
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
+func evalIfExpression(ifExpr *ast.IfExpression, env *Environment) Object {
+	condition := Eval(ifExpr.Condition, env)
+	if isError(condition) {
+		return condition
+	}
+
+	var result Object
+	if isTruthy(condition) {
+		result = Eval(ifExpr.Consequence, env)
+	} else if ifExpr.Alternative != nil {
+		result = Eval(ifExpr.Alternative, env)
+	}
+
+	// an empty block or one ending in a let statement has no value
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
+// compoundOperators maps the compound assignment operators to the infix operator they apply.
+var compoundOperators = map[string]string{"+=": "+", "-=": "-", "*=": "*", "/=": "/"}
+
+// evalAssignExpression evaluates an assignment to the nearest binding of an identifier or to an element
+// of an array or hash. The target is evaluated first, then for a compound operator its current value,
+// then the value assigned. The result is the new value.
+func evalAssignExpression(assignExpr *ast.AssignExpression, env *Environment) Object {
+	switch target := assignExpr.Target.(type) {
+	case *ast.Identifier:
+		current, ok := env.Get(target.Value)
+		if !ok {
+			return undeclaredAssignment(target.Value)
+		}
+		value := evalAssignedValue(assignExpr, current, env)
+		if isError(value) {
+			return value
+		}
+		env.Assign(target.Value, value)
+		return value
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if isError(index) {
+			return index
+		}
+		var current Object
+		if assignExpr.Operator != "=" {
+			if current = evalIndexExpression(left, index); isError(current) {
+				return current
+			}
+		}
+		value := evalAssignedValue(assignExpr, current, env)
+		if isError(value) {
+			return value
+		}
+		return evalIndexAssignment(left, index, value)
+	default:
+		return NewError("cannot assign to %s", assignExpr.Target.String())
+	}
+}
+
+// evalAssignedValue evaluates the right-hand side of an assignment and combines it with the current
+// value of the target for a compound operator.
+func evalAssignedValue(assignExpr *ast.AssignExpression, current Object, env *Environment) Object {
+	value := Eval(assignExpr.Value, env)
+	if isError(value) || assignExpr.Operator == "=" {
+		return value
+	}
+	return evalInfixExpression(compoundOperators[assignExpr.Operator], current, value)
+}
+
+func undeclaredAssignment(name string) Object {
+	if _, ok := LookupBuiltin(name); ok {
+		return NewError("cannot assign to builtin: %s", name)
+	}
+	return NewError("cannot assign to undeclared identifier: %s", name)
+}
+
+func evalIndexAssignment(left, index, value Object) Object {
+	switch {
+	case left.Type() == ArrayType && index.Type() == IntegerType:
+		array, i := left.(*ArrayObject), index.(*IntegerObject).Value
+		if i < 0 || i >= int64(len(array.Elements)) {
+			return NewError("index out of range: %d with length %d", i, len(array.Elements))
+		}
+		array.Elements[i] = value
+		return value
+	case left.Type() == HashType:
+		key, ok := index.(Hashable)
+		if !ok {
+			return NewError("unusable as hash key: %s", index.Type())
+		}
+		left.(*HashObject).Set(key, value)
+		return value
+	default:
+		return NewError("index assignment not supported: %s[%s]", left.Type(), index.Type())
+	}
+}
+
+func evalIndexExpression(left, index Object) Object {
+	switch {
+	case left.Type() == ArrayType && index.Type() == IntegerType:
+		return evalArrayIndexExpression(left.(*ArrayObject), index.(*IntegerObject))
+	case left.Type() == HashType:
+		return evalHashIndexExpression(left.(*HashObject), index)
+	default:
+		return NewError("index operator not supported: %s[%s]", left.Type(), index.Type())
+	}
+}
+
+func evalArrayIndexExpression(array *ArrayObject, index *IntegerObject) Object {
+	if index.Value < 0 || index.Value >= int64(len(array.Elements)) {
+		return NewError("index out of range: %d with length %d", index.Value, len(array.Elements))
+	}
+	return array.Elements[index.Value]
+}
+
+func evalHashIndexExpression(hash *HashObject, index Object) Object {
+	key, ok := index.(Hashable)
+	if !ok {
+		return NewError("unusable as hash key: %s", index.Type())
+	}
+	if value, ok := hash.Get(key); ok {
+		return value
+	}
+	return NULL
+}
+
+func evalHashLiteral(hashLiteral *ast.HashLiteral, env *Environment) Object {
+	hash := NewHashObject()
+	for _, pair := range hashLiteral.Pairs {
+		key := Eval(pair.Key, env)
+		if isError(key) {
+			return key
+		}
+		hashKey, ok := key.(Hashable)
+		if !ok {
+			return NewError("unusable as hash key: %s", key.Type())
+		}
+
+		value := Eval(pair.Value, env)
+		if isError(value) {
+			return value
+		}
+		hash.Set(hashKey, value)
+	}
+	return hash
+}
+
+func evalIdentifier(id *ast.Identifier, env *Environment) Object {
+	if value, ok := env.Get(id.Value); ok {
+		return value
+	}
+	if builtin, ok := LookupBuiltin(id.Value); ok {
+		return builtin
+	}
+	return NewError("identifier not found: %s", id.Value)
+}
+
+func evalExpressions(exprs []ast.Expression, env *Environment) []Object {
+	var result []Object
+	for _, expr := range exprs {
+		value := Eval(expr, env)
+		if isError(value) {
+			return []Object{value}
+		}
+		result = append(result, value)
+	}
+	return result
+}
+
+// MaxCallDepth is the number of nested function calls after which a call fails with a stack overflow error.
+const MaxCallDepth = 1023
+
+// applyFunction calls fn from the call site at pos in the environment caller. pos is recorded in the trace of
+// errors raised in the body.
+func applyFunction(fn Object, args []Object, caller *Environment, pos token.Position) Object {
+	if builtin, ok := fn.(*BuiltinObject); ok {
+		if result := builtin.Fn(args...); result != nil {
+			return result
+		}
+		return NULL
+	}
+
+	function, ok := fn.(*FunctionObject)
+	if !ok {
+		return NewError("not a function: %s", fn.Type())
+	}
+	if len(args) != len(function.Params) {
+		return wrongNumberOfArguments(len(function.Params), len(args))
+	}
+	if caller.depth == MaxCallDepth {
+		return NewError("stack overflow")
+	}
+
+	env := NewEnclosedEnvironment(function.Env)
+	env.depth = caller.depth + 1
+	for i, param := range function.Params {
+		env.Set(param.Value, args[i])
+	}
+
+	result := Eval(function.Body, env)
+	switch result := result.(type) {
+	case *ReturnValueObject:
+		return result.Value
+	case *ErrorObject:
+		result.Trace = append(result.Trace, Frame{Function: FunctionName(function.Name), Pos: pos})
+	case nil:
+		return NULL
+	}
+	return result
+}
+
+// FunctionName returns the name used for a function in stack traces.
+func FunctionName(name string) string {
+	if name == "" {
+		return "<anonymous>"
+	}
+	return name
+}
+
+func isTruthy(object Object) bool {
+	switch object {
+	case NULL, FALSE:
+		return false
+	default:
+		return true
+	}
+}
+
+func isError(object Object) bool {
+	return object != nil && object.Type() == ErrorType
+}
+
+func nativeBoolToBooleanObject(value bool) *BooleanObject {
+	if value {
+		return TRUE
+	}
+	return FALSE
+}
+
+// NewError creates an error object, e.g. for a builtin function to report bad arguments.
+func NewError(format string, a ...interface{}) *ErrorObject {
+	return &ErrorObject{Message: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,271 @@
+package eval
+
+import (
+	"bytes"
+	"monkey/ast"
+	"monkey/token"
+	"os"
+	"sync"
+	"testing"
+)
+
+func testEval(input string) Object {
+	program, _ := ast.NewParser(token.NewLexer(input)).Parse()
+	return Eval(program, NewEnvironment())
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5", "5"},
+		{"-5 + 10 * 2", "15"},
+		{"(5 + 10) / 3", "5"},
+		{"!true", "false"},
+		{"!!5", "true"},
+		{"1 < 2 == true", "true"},
+		{"true != false", "true"},
+		{"if (1 > 2) { 10 }", "null"},
+		{"if (1 < 2) { 10 } else { 20 }", "10"},
+		{"if (10 > 1) { if (10 > 1) { return 10; } return 1; }", "10"},
+		{"let a = 5\nlet b = a * 2\nb", "10"},
+		{"let add = fn(x, y) { x + y; }; add(2, add(3, 4))", "9"},
+		{"fn(x) { return x * 2; 0 }(4)", "8"},
+		{"let adder = fn(x) { fn(y) { x + y } }; let addTwo = adder(2); addTwo(3)", "5"},
+		{"let x = 10; let f = fn() { x }; let g = fn(x) { f() }; g(1)", "10"},
+		{"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(10)", "55"},
+		{"let x = 1; let f = fn(x) { let x = x + 1; x }; f(5) + x", "7"},
+		{`"Hello" + ", " + "World!"`, "Hello, World!"},
+		{`"a\tb\n\"c\"\\"`, "a\tb\n\"c\"\\"},
+		{`"\u{4f60}\u{597d}"`, "你好"},
+		{`"abc" == "a" + "bc"`, "true"},
+		{`"abc" != "abc"`, "false"},
+		{`"a" - "b"`, "ERROR: unknown operator: STRING - STRING"},
+		{`"a" + 1`, "ERROR: type mismatch: STRING + INTEGER"},
+		{"[1, 2 * 2, 3 + 3]", "[1, 4, 6]"},
+		{"[]", "[]"},
+		{"let xs = [1, 2, 3]; xs[1]", "2"},
+		{"let i = 0; [1][i]", "1"},
+		{"[[1, 2], [3]][0][1] * 10", "20"},
+		{"fn() { [fn(x) { x + 1 }] }()[0](41)", "42"},
+		{"[1, 2, 3][3]", "ERROR: index out of range: 3 with length 3"},
+		{"[1, 2, 3][-1]", "ERROR: index out of range: -1 with length 3"},
+		{"1[0]", "ERROR: index operator not supported: INTEGER[INTEGER]"},
+		{`{"one": 1, "two": 1 + 1, 3: "three", true: [4]}`, "{one: 1, two: 2, 3: three, true: [4]}"},
+		{"{}", "{}"},
+		{`let h = {"a": 1, "b": 2}; h["b"]`, "2"},
+		{`let key = "a"; {"a": 5}[key]`, "5"},
+		{`{"a": 5}["b"]`, "null"},
+		{"{1: 1, 1: 2}[1]", "2"},
+		{"{false: 0}[1 > 2]", "0"},
+		{`{"a": 1}[fn(x) { x }]`, "ERROR: unusable as hash key: FUNCTION"},
+		{"{[1]: 1}", "ERROR: unusable as hash key: ARRAY"},
+		{`len("")`, "0"},
+		{`len("héllo")`, "5"},
+		{"len([1, 2, 3])", "3"},
+		{`len({"a": 1})`, "1"},
+		{"len(1)", "ERROR: argument to `len` not supported, got INTEGER"},
+		{`len("a", "b")`, "ERROR: wrong number of arguments: want=1, got=2"},
+		{"first([1, 2, 3])", "1"},
+		{"first([])", "null"},
+		{"last([1, 2, 3])", "3"},
+		{"rest([1, 2, 3])", "[2, 3]"},
+		{"rest([])", "null"},
+		{"let a = [1]; let b = push(a, 2); [a, b]", "[[1], [1, 2]]"},
+		{"first(1)", "ERROR: argument to `first` must be ARRAY, got INTEGER"},
+		{"let len = fn(x) { 42 }; len([])", "42"},
+		{`let 名字 = "猴子"; 名字 + "!"`, "猴子!"},
+		{"let snake_case_2 = 2; let _x = 3; snake_case_2 * _x", "6"},
+		{"0xFF", "255"},
+		{"0o755", "493"},
+		{"0b1010 + 0B1", "11"},
+		{"1_000_000 * 2", "2000000"},
+		{"0x7FFF_FFFF_FFFF_FFFF", "9223372036854775807"},
+		{"1.5", "1.5"},
+		{"2.0", "2.0"},
+		{"1e-3", "0.001"},
+		{"2.5E+3", "2500.0"},
+		{"1e21", "1e+21"},
+		{"-1.5", "-1.5"},
+		{"0.1 + 0.2", "0.30000000000000004"},
+		{"1 + 0.5", "1.5"},
+		{"0.5 * 4", "2.0"},
+		{"7 / 2", "3"},
+		{"7 / 2.0", "3.5"},
+		{"1 / 0.0", "ERROR: division by zero"},
+		{"1 == 1.0", "true"},
+		{"1 != 1.5", "true"},
+		{"1 < 1.5", "true"},
+		{"2.5 > 3", "false"},
+		{"1.5 + true", "ERROR: type mismatch: FLOAT + BOOLEAN"},
+		{`"a" + 1.5`, "ERROR: type mismatch: STRING + FLOAT"},
+		{"[1, 2][1.0]", "ERROR: index operator not supported: ARRAY[FLOAT]"},
+		{"{1.5: 1}", "ERROR: unusable as hash key: FLOAT"},
+		{"1 <= 1", "true"},
+		{"2 >= 3", "false"},
+		{"1.5 <= 1", "false"},
+		{"2 >= 1.5", "true"},
+		{"1 < 2 && 2 < 3", "true"},
+		{"1 < 2 && 3 < 2", "false"},
+		{"false || 1 > 0", "true"},
+		{"null_value && 1", "ERROR: identifier not found: null_value"},
+		{"false && undefined", "false"},
+		{"true || undefined()", "true"},
+		{"1 && [1]", "true"},
+		{"if (false) { 1 } || 0", "true"},
+		{"true && 1 + true", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+		{`"a" <= "b"`, "ERROR: unknown operator: STRING <= STRING"},
+		{"5 + true", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+		{"-true", "ERROR: unknown operator: -BOOLEAN"},
+		{"true + false; 5", "ERROR: unknown operator: BOOLEAN + BOOLEAN"},
+		{"foo", "ERROR: identifier not found: foo"},
+		{"5 / 0", "ERROR: division by zero"},
+		{"7 % 3", "1"},
+		{"-7 % 3", "-1"},
+		{"7.5 % 2", "1.5"},
+		{"5 % 0", "ERROR: modulo by zero"},
+		{"5.0 % 0", "ERROR: modulo by zero"},
+		{"2 ** 10", "1024"},
+		{"2 ** 3 ** 2", "512"},
+		{"-2 ** 2", "-4"},
+		{"(-3) ** 3", "-27"},
+		{"7 ** 0", "1"},
+		{"2 ** 0.5 > 1.41", "true"},
+		{"2 ** -1", "ERROR: negative exponent: -1"},
+		{"2.0 ** -1", "0.5"},
+		{"12 & 10", "8"},
+		{"12 | 10", "14"},
+		{"12 ^ 10", "6"},
+		{"~5", "-6"},
+		{"1 << 10", "1024"},
+		{"-16 >> 2", "-4"},
+		{"1 << 64", "0"},
+		{"1 << -1", "ERROR: negative shift count: -1"},
+		{"1 >> -2", "ERROR: negative shift count: -2"},
+		{"1.0 & 1", "ERROR: unknown operator: FLOAT & INTEGER"},
+		{"~1.5", "ERROR: unknown operator: ~FLOAT"},
+		{"~true", "ERROR: unknown operator: ~BOOLEAN"},
+		{"let x = 1; x = 2; x", "2"},
+		{"let x = 1; x = x + 1", "2"},
+		{"let x = 1; let y = 2; x = y = 5; [x, y]", "[5, 5]"},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", "6"},
+		{"let x = 1; x += 0.5", "1.5"},
+		{"let x = 1; let f = fn() { x = 2 }; f(); x", "2"},
+		{"let x = 1; let f = fn(x) { x = 2 }; f(0); x", "1"},
+		{"let f = fn() { let x = 1; x = 2 }; f(); x", "ERROR: identifier not found: x"},
+		{"let c = fn() { let n = 0; fn() { n += 1 } }(); c(); c(); c()", "3"},
+		{"let a = [1, 2]; let b = a; b[1] = 5; a", "[1, 5]"},
+		{"let a = [1, 2]; a[0] += 10", "11"},
+		{"let h = {}; h[\"k\"] = 1; h[\"k\"] *= 3; h", "{k: 3}"},
+		{"x = 1", "ERROR: cannot assign to undeclared identifier: x"},
+		{"x += 1", "ERROR: cannot assign to undeclared identifier: x"},
+		{"len = 1", "ERROR: cannot assign to builtin: len"},
+		{"let x = true; x += 1", "ERROR: type mismatch: BOOLEAN + INTEGER"},
+		{"let a = [1]; a[1] = 2", "ERROR: index out of range: 1 with length 1"},
+		{"let a = [1]; a[-1] += 2", "ERROR: index out of range: -1 with length 1"},
+		{"let h = {}; h[[1]] = 2", "ERROR: unusable as hash key: ARRAY"},
+		{"let s = \"ab\"; s[0] = \"c\"", "ERROR: index assignment not supported: STRING[INTEGER]"},
+		{"let h = {}; h[\"k\"] += 1", "ERROR: type mismatch: NULL + INTEGER"},
+		{"1(2)", "ERROR: not a function: INTEGER"},
+		{"let f = fn() { f() }; f()", "ERROR: stack overflow"},
+		{"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(1022)", "0"},
+		{"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(1023)", "ERROR: stack overflow"},
+	}
+
+	for _, tt := range tests {
+		result := testEval(tt.input)
+		if result == nil {
+			t.Errorf("%q: got nil", tt.input)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	var out bytes.Buffer
+	Output = &out
+	defer func() { Output = os.Stdout }()
+
+	RegisterBuiltin("double", func(args ...Object) Object {
+		if len(args) != 1 || args[0].Type() != IntegerType {
+			return NewError("double expects an integer")
+		}
+		return &IntegerObject{Value: args[0].(*IntegerObject).Value * 2}
+	})
+
+	result := testEval(`puts("a", 1 + 1); puts(double(21)); double(true)`)
+	if result.Inspect() != "ERROR: double expects an integer" {
+		t.Errorf("unexpected result %s", result.Inspect())
+	}
+	if out.String() != "a\n2\n42\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+}
+
+func TestConcurrentCallDepth(t *testing.T) {
+	// every evaluation may nest MaxCallDepth calls, however many run at the same time
+	var wg sync.WaitGroup
+	results := make([]Object, 4)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = testEval("let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(1000)")
+		}(i)
+	}
+	wg.Wait()
+	for i, result := range results {
+		if result.Inspect() != "1000" {
+			t.Errorf("evaluation %d: expected 1000, got %s", i, result.Inspect())
+		}
+	}
+}
+
+// collidingKey is a string key whose hash is the same for every value.
+type collidingKey struct {
+	StringObject
+}
+
+func (key *collidingKey) HashKey() HashKey {
+	return HashKey{Type: StringType, Value: 1}
+}
+
+func TestHashCollisions(t *testing.T) {
+	hash := NewHashObject()
+	a, b := &collidingKey{StringObject{Value: "a"}}, &collidingKey{StringObject{Value: "b"}}
+	hash.Set(a, &IntegerObject{Value: 1})
+	hash.Set(b, &IntegerObject{Value: 2})
+	hash.Set(&collidingKey{StringObject{Value: "a"}}, &IntegerObject{Value: 3})
+
+	if value, ok := hash.Get(b); !ok || value.Inspect() != "2" {
+		t.Errorf("expected b to map to 2, got %v", value)
+	}
+	if _, ok := hash.Get(&collidingKey{StringObject{Value: "c"}}); ok {
+		t.Errorf("expected c to be missing")
+	}
+	if hash.Len() != 2 || hash.Inspect() != "{a: 3, b: 2}" {
+		t.Errorf("unexpected hash %s", hash.Inspect())
+	}
+}
+
+func TestErrorTrace(t *testing.T) {
+	input := `let inner = fn(x) { x + true };
+let outer = fn(x) { inner(x) * 2 };
+fn() { outer(1) }()`
+
+	err, ok := testEval(input).(*ErrorObject)
+	if !ok {
+		t.Fatalf("expected an error")
+	}
+	if err.Pos != (token.Position{Offset: 22, Line: 1, Column: 23, ByteColumn: 23}) {
+		t.Errorf("unexpected position %+v", err.Pos)
+	}
+	expected := "\tin inner called at 2:21\n\tin outer called at 3:8\n\tin <anonymous> called at 3:1\n"
+	if err.StackTrace() != expected {
+		t.Errorf("expected %q, got %q", expected, err.StackTrace())
+	}
+}
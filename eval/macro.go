@@ -0,0 +1,140 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"monkey/ast"
+	"monkey/token"
+	"strconv"
+)
+
+// quote wraps node without evaluating it, except for the unquote(...) calls inside it
+// which are evaluated in env and replaced by their value.
+func quote(node ast.Node, env *Environment) Object {
+	return &QuoteObject{Node: evalUnquoteCalls(node, env)}
+}
+
+// The quoted code is copied first, as it belongs to a function or macro body that may run again.
+func evalUnquoteCalls(quoted ast.Node, env *Environment) ast.Node {
+	return ast.Modify(ast.Copy(quoted), func(node ast.Node) ast.Node {
+		call, ok := node.(*ast.CallExpression)
+		if !ok || len(call.Arguments) != 1 {
+			return node
+		}
+		if id, ok := call.Function.(*ast.Identifier); !ok || id.Value != "unquote" {
+			return node
+		}
+
+		value := Eval(call.Arguments[0], env)
+		if replacement := objectToNode(value, call.Pos()); replacement != nil {
+			return replacement
+		}
+		return node
+	})
+}
+
+// objectToNode turns the value of an unquote call back into code. Values that have no literal form stay unquoted.
+func objectToNode(object Object, pos token.Position) ast.Node {
+	switch object := object.(type) {
+	case *IntegerObject:
+		literal := strconv.FormatInt(object.Value, 10)
+		return &ast.Integer{Token: &token.Token{Type: token.Int, Literal: literal, Pos: pos}, Value: object.Value}
+	case *FloatObject:
+		if math.IsInf(object.Value, 0) || math.IsNaN(object.Value) {
+			return nil
+		}
+		literal := object.Inspect()
+		return &ast.FloatLiteral{Token: &token.Token{Type: token.Float, Literal: literal, Pos: pos}, Value: object.Value}
+	case *BooleanObject:
+		if object.Value {
+			return &ast.Boolean{Token: &token.Token{Type: token.True, Literal: "true", Pos: pos}, Value: true}
+		}
+		return &ast.Boolean{Token: &token.Token{Type: token.False, Literal: "false", Pos: pos}, Value: false}
+	case *StringObject:
+		return &ast.StringLiteral{Token: &token.Token{Type: token.String, Literal: object.Value, Pos: pos}, Value: object.Value}
+	case *QuoteObject:
+		return object.Node
+	default:
+		return nil
+	}
+}
+
+// DefineMacros moves the top-level `let name = macro(...) {...}` statements of program into env.
+func DefineMacros(program *ast.Program, env *Environment) {
+	statements := program.Statements[:0]
+	for _, stmt := range program.Statements {
+		letStmt, ok := stmt.(*ast.LetStatement)
+		if !ok {
+			statements = append(statements, stmt)
+			continue
+		}
+		macro, ok := letStmt.Value.(*ast.MacroLiteral)
+		if !ok {
+			statements = append(statements, stmt)
+			continue
+		}
+		env.Set(letStmt.Name.Value, &MacroObject{Params: macro.Params, Body: macro.Body, Env: env})
+	}
+	program.Statements = statements
+}
+
+// ExpandMacros replaces every call of a macro defined in env by the code the macro returns.
+// The arguments are passed to the macro quoted, and the macro must return a quote.
+func ExpandMacros(program *ast.Program, env *Environment) (*ast.Program, error) {
+	var err error
+	expanded := ast.Modify(program, func(node ast.Node) ast.Node {
+		call, ok := node.(*ast.CallExpression)
+		if !ok || err != nil {
+			return node
+		}
+		macro, ok := lookupMacro(call, env)
+		if !ok {
+			return node
+		}
+		if len(call.Arguments) != len(macro.Params) {
+			err = fmt.Errorf("%s: macro %s: wrong number of arguments: want=%d, got=%d",
+				call.Pos(), call.Function, len(macro.Params), len(call.Arguments))
+			return node
+		}
+
+		macroEnv := NewEnclosedEnvironment(macro.Env)
+		for i, param := range macro.Params {
+			macroEnv.Set(param.Value, &QuoteObject{Node: call.Arguments[i]})
+		}
+
+		result := Eval(macro.Body, macroEnv)
+		if retVal, ok := result.(*ReturnValueObject); ok {
+			result = retVal.Value
+		}
+		quote, ok := result.(*QuoteObject)
+		if !ok {
+			err = fmt.Errorf("%s: macro %s must return a quote, got %s", call.Pos(), call.Function, describeType(result))
+			return node
+		}
+		return quote.Node
+	})
+	return expanded.(*ast.Program), err
+}
+
+func lookupMacro(call *ast.CallExpression, env *Environment) (*MacroObject, bool) {
+	id, ok := call.Function.(*ast.Identifier)
+	if !ok {
+		return nil, false
+	}
+	object, ok := env.Get(id.Value)
+	if !ok {
+		return nil, false
+	}
+	macro, ok := object.(*MacroObject)
+	return macro, ok
+}
+
+func describeType(object Object) string {
+	if object == nil {
+		return "nothing"
+	}
+	if err, ok := object.(*ErrorObject); ok {
+		return err.Inspect()
+	}
+	return object.Type()
+}
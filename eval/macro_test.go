@@ -0,0 +1,88 @@
+package eval
+
+import (
+	"monkey/ast"
+	"monkey/token"
+	"testing"
+)
+
+func TestQuoteUnquote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"quote(5)", "5"},
+		{"quote(foobar + barfoo)", "(foobar+barfoo)"},
+		{"quote(unquote(4 + 4) + 8)", "(8+8)"},
+		{"let a = 8; quote(unquote(a) + a)", "(8+a)"},
+		{"quote(unquote(true == false))", "false"},
+		{"quote(unquote(1.5 * 2) + 1)", "(3.0+1)"},
+		{`quote(unquote("a" + "b"))`, `"ab"`},
+		{"let q = quote(4 + 4); quote(unquote(q) * 2)", "((4+4)*2)"},
+	}
+
+	for _, tt := range tests {
+		quote, ok := testEval(tt.input).(*QuoteObject)
+		if !ok {
+			t.Errorf("%q: expected a quote", tt.input)
+			continue
+		}
+		if quote.Node.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.expected, quote.Node.String())
+		}
+	}
+}
+
+func expand(t *testing.T, input string) (*ast.Program, error) {
+	program, errs := ast.NewParser(token.NewLexer(input)).Parse()
+	if len(errs) != 0 {
+		t.Fatalf("%q: parse errors %v", input, errs)
+	}
+	env := NewEnvironment()
+	DefineMacros(program, env)
+	return ExpandMacros(program, env)
+}
+
+func TestExpandMacros(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let infix = macro() { quote(1 + 2) }; infix()", "(1+2)\n"},
+		{"let reverse = macro(a, b) { quote(unquote(b) - unquote(a)) }; reverse(2 + 2, 10 - 5)", "((10-5)-(2+2))\n"},
+		{
+			`let unless = macro(cond, cons, alt) { quote(if (!(unquote(cond))) { unquote(cons) } else { unquote(alt) }) };
+unless(10 > 5, puts("not greater"), puts("greater"))`,
+			"if (!(10>5)) {\n\tputs(\"not greater\")\n}\nelse {\n\tputs(\"greater\")\n}\n\n\n",
+		},
+		{"let twice = macro(x) { quote(unquote(x) * 2) }; puts(twice(twice(3)))", "puts(((3*2)*2))\n"},
+	}
+
+	for _, tt := range tests {
+		program, err := expand(t, tt.input)
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", tt.input, err)
+			continue
+		}
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestExpandMacrosErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let m = macro(x) { 1 }; m(2)", "1:25: macro m must return a quote, got INTEGER"},
+		{"let m = macro(x) { quote(x) }; m()", "1:32: macro m: wrong number of arguments: want=1, got=0"},
+	}
+
+	for _, tt := range tests {
+		_, err := expand(t, tt.input)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: expected error %q, got %v", tt.input, tt.expected, err)
+		}
+	}
+}
@@ -2,15 +2,32 @@ package eval
 
 import (
 	"fmt"
+	"hash/fnv"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/token"
 	"strconv"
+	"strings"
 )
 
 type ObjectType = string
 
 const (
-	IntegerType = "INTEGER"
-	BooleanType = "BOOLEAN"
-	NullType    = "NULL"
+	IntegerType     = "INTEGER"
+	FloatType       = "FLOAT"
+	BooleanType     = "BOOLEAN"
+	NullType        = "NULL"
+	StringType      = "STRING"
+	ArrayType       = "ARRAY"
+	HashType        = "HASH"
+	ReturnValueType = "RETURN_VALUE"
+	FunctionType    = "FUNCTION"
+	BuiltinType     = "BUILTIN"
+	QuoteType       = "QUOTE"
+	MacroType       = "MACRO"
+
+	CompiledFunctionType = "COMPILED_FUNCTION"
+	ErrorType            = "ERROR"
 )
 
 type Object interface {
@@ -18,6 +35,18 @@ type Object interface {
 	Inspect() string
 }
 
+// HashKey is the hash of a hashable value inside a HashObject. Different strings may share one.
+type HashKey struct {
+	Type  ObjectType
+	Value uint64
+}
+
+// Hashable is implemented by the objects that can be used as hash keys.
+type Hashable interface {
+	Object
+	HashKey() HashKey
+}
+
 type IntegerObject struct {
 	Value int64
 }
@@ -30,6 +59,29 @@ func (integer *IntegerObject) Inspect() string {
 	return strconv.FormatInt(integer.Value, 10)
 }
 
+func (integer *IntegerObject) HashKey() HashKey {
+	return HashKey{Type: integer.Type(), Value: uint64(integer.Value)}
+}
+
+// FloatObject is not hashable: a key 1.0 would have to match both 1 and the floats that round to it.
+type FloatObject struct {
+	Value float64
+}
+
+func (float *FloatObject) Type() ObjectType {
+	return FloatType
+}
+
+// Inspect prints the shortest representation that reads back as the same value, keeping a
+// fraction on whole numbers so that 2.0 is not mistaken for an integer.
+func (float *FloatObject) Inspect() string {
+	s := strconv.FormatFloat(float.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
 type BooleanObject struct {
 	Value bool
 }
@@ -42,6 +94,13 @@ func (boolean *BooleanObject) Inspect() string {
 	return fmt.Sprintf("%t", boolean.Value)
 }
 
+func (boolean *BooleanObject) HashKey() HashKey {
+	if boolean.Value {
+		return HashKey{Type: boolean.Type(), Value: 1}
+	}
+	return HashKey{Type: boolean.Type(), Value: 0}
+}
+
 type NullObject struct {
 }
 
@@ -52,3 +111,273 @@ func (null *NullObject) Type() ObjectType {
 func (null *NullObject) Inspect() string {
 	return "null"
 }
+
+type StringObject struct {
+	Value string
+}
+
+func (str *StringObject) Type() ObjectType {
+	return StringType
+}
+
+func (str *StringObject) Inspect() string {
+	return str.Value
+}
+
+func (str *StringObject) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(str.Value))
+	return HashKey{Type: str.Type(), Value: h.Sum64()}
+}
+
+type ArrayObject struct {
+	Elements []Object
+}
+
+func (array *ArrayObject) Type() ObjectType {
+	return ArrayType
+}
+
+func (array *ArrayObject) Inspect() string {
+	var elements []string
+	for _, element := range array.Elements {
+		elements = append(elements, element.Inspect())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+type HashPair struct {
+	Key   Hashable
+	Value Object
+}
+
+// HashObject maps hashable keys to values and remembers the order in which keys were first added.
+// buckets holds the pairs of every HashKey, as keys with the same hash may still differ.
+type HashObject struct {
+	buckets map[HashKey][]*HashPair
+	order   []*HashPair
+}
+
+func NewHashObject() *HashObject {
+	return &HashObject{buckets: make(map[HashKey][]*HashPair)}
+}
+
+func (hash *HashObject) Type() ObjectType {
+	return HashType
+}
+
+func (hash *HashObject) Inspect() string {
+	var pairs []string
+	for _, pair := range hash.OrderedPairs() {
+		pairs = append(pairs, pair.Key.Inspect()+": "+pair.Value.Inspect())
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+func (hash *HashObject) Get(key Hashable) (Object, bool) {
+	if pair := hash.lookup(key, key.HashKey()); pair != nil {
+		return pair.Value, true
+	}
+	return nil, false
+}
+
+func (hash *HashObject) Set(key Hashable, value Object) {
+	hashKey := key.HashKey()
+	if pair := hash.lookup(key, hashKey); pair != nil {
+		pair.Value = value
+		return
+	}
+	pair := &HashPair{Key: key, Value: value}
+	hash.buckets[hashKey] = append(hash.buckets[hashKey], pair)
+	hash.order = append(hash.order, pair)
+}
+
+// lookup returns the pair of key, whose hash is hashKey, or nil.
+func (hash *HashObject) lookup(key Hashable, hashKey HashKey) *HashPair {
+	for _, pair := range hash.buckets[hashKey] {
+		if sameKey(pair.Key, key) {
+			return pair
+		}
+	}
+	return nil
+}
+
+// sameKey reports whether two keys with the same HashKey are equal. Integers and booleans are their
+// own HashKey, strings have to be compared.
+func sameKey(a, b Hashable) bool {
+	switch a := a.(type) {
+	case *IntegerObject, *BooleanObject:
+		return true
+	case *StringObject:
+		b, ok := b.(*StringObject)
+		return ok && a.Value == b.Value
+	default:
+		return a.Type() == b.Type() && a.Inspect() == b.Inspect()
+	}
+}
+
+// Len returns the number of keys.
+func (hash *HashObject) Len() int {
+	return len(hash.order)
+}
+
+// OrderedPairs returns the pairs in insertion order.
+func (hash *HashObject) OrderedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(hash.order))
+	for _, pair := range hash.order {
+		pairs = append(pairs, *pair)
+	}
+	return pairs
+}
+
+// ReturnValueObject wraps the value of a return statement while it bubbles up to the enclosing function or program.
+type ReturnValueObject struct {
+	Value Object
+}
+
+func (retVal *ReturnValueObject) Type() ObjectType {
+	return ReturnValueType
+}
+
+func (retVal *ReturnValueObject) Inspect() string {
+	return retVal.Value.Inspect()
+}
+
+// FunctionObject is a closure: it keeps the environment it was defined in.
+// Name is empty for functions that are not directly bound by a let statement.
+type FunctionObject struct {
+	Params []*ast.Identifier
+	Body   *ast.BlockStatement
+	Env    *Environment
+	Name   string
+}
+
+func (function *FunctionObject) Type() ObjectType {
+	return FunctionType
+}
+
+func (function *FunctionObject) Inspect() string {
+	var params []string
+	for _, param := range function.Params {
+		params = append(params, param.String())
+	}
+	return "fn(" + strings.Join(params, ", ") + ") " + function.Body.String()
+}
+
+// CompiledFunctionObject is a function body compiled to bytecode. It lives in the constant pool
+// and is turned into a closure when the function literal is evaluated.
+type CompiledFunctionObject struct {
+	Instructions  code.Instructions
+	Lines         code.LineTable
+	NumLocals     int
+	NumParameters int
+	Name          string
+
+	// LocalNames are the names of the local slots, for the errors about locals read before their let ran
+	LocalNames []string
+}
+
+func (function *CompiledFunctionObject) Type() ObjectType {
+	return CompiledFunctionType
+}
+
+func (function *CompiledFunctionObject) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", function)
+}
+
+// ClosureObject is a compiled function together with the free variables it captured.
+// It reports FunctionType so that both engines describe functions the same way.
+type ClosureObject struct {
+	Fn   *CompiledFunctionObject
+	Free []Object
+}
+
+func (closure *ClosureObject) Type() ObjectType {
+	return FunctionType
+}
+
+func (closure *ClosureObject) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", closure)
+}
+
+type BuiltinFunction = func(args ...Object) Object
+
+type BuiltinObject struct {
+	Name string
+	Fn   BuiltinFunction
+}
+
+func (builtin *BuiltinObject) Type() ObjectType {
+	return BuiltinType
+}
+
+func (builtin *BuiltinObject) Inspect() string {
+	return "builtin function " + builtin.Name
+}
+
+// Frame is a function call that a runtime error propagated through.
+type Frame struct {
+	Function string
+	Pos      token.Position
+}
+
+// QuoteObject is an unevaluated piece of code, produced by quote(...).
+type QuoteObject struct {
+	Node ast.Node
+}
+
+func (quote *QuoteObject) Type() ObjectType {
+	return QuoteType
+}
+
+func (quote *QuoteObject) Inspect() string {
+	return "QUOTE(" + quote.Node.String() + ")"
+}
+
+type MacroObject struct {
+	Params []*ast.Identifier
+	Body   *ast.BlockStatement
+	Env    *Environment
+}
+
+func (macro *MacroObject) Type() ObjectType {
+	return MacroType
+}
+
+func (macro *MacroObject) Inspect() string {
+	var params []string
+	for _, param := range macro.Params {
+		params = append(params, param.String())
+	}
+	return "macro(" + strings.Join(params, ", ") + ") " + macro.Body.String()
+}
+
+// ErrorObject is a runtime error. Pos is where it was raised and Trace lists
+// the calls it propagated through, innermost first.
+type ErrorObject struct {
+	Message string
+	Pos     token.Position
+	Trace   []Frame
+}
+
+func (err *ErrorObject) Type() ObjectType {
+	return ErrorType
+}
+
+func (err *ErrorObject) Inspect() string {
+	return "ERROR: " + err.Message
+}
+
+// Error lets the VM return an ErrorObject as a Go error.
+func (err *ErrorObject) Error() string {
+	return err.Message
+}
+
+// StackTrace returns one line per frame of Trace, each prefixed with a tab.
+func (err *ErrorObject) StackTrace() string {
+	var builder strings.Builder
+	for _, frame := range err.Trace {
+		builder.WriteString(fmt.Sprintf("\tin %s called at %s\n", frame.Function, frame.Pos))
+	}
+	return builder.String()
+}
@@ -0,0 +1,29 @@
+package eval
+
+// The operations below expose the evaluator's semantics for values that are already computed.
+// The VM uses them so that both engines agree on results and error messages.
+
+func PrefixOperation(operator string, right Object) Object {
+	return evalPrefixExpression(operator, right)
+}
+
+func InfixOperation(operator string, left, right Object) Object {
+	return evalInfixExpression(operator, left, right)
+}
+
+func IndexOperation(left, index Object) Object {
+	return evalIndexExpression(left, index)
+}
+
+// SetIndexOperation assigns value to left[index] and returns value.
+func SetIndexOperation(left, index, value Object) Object {
+	return evalIndexAssignment(left, index, value)
+}
+
+func IsTruthy(object Object) bool {
+	return isTruthy(object)
+}
+
+func WrongNumberOfArguments(want, got int) *ErrorObject {
+	return wrongNumberOfArguments(want, got)
+}
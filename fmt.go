@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"monkey/format"
+	"os"
+)
+
+// formatFile prints the script at path in its canonical layout, or with write rewrites the file in place.
+func formatFile(path string, write bool, stdout io.Writer, stderr io.Writer) int {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	formatted, errs := format.Source(string(source))
+	if len(errs) != 0 {
+		for _, err := range errs {
+			fmt.Fprintf(stderr, "%s:%s\n", path, err)
+		}
+		return exitError
+	}
+
+	if !write {
+		fmt.Fprint(stdout, formatted)
+		return exitOK
+	}
+	if formatted == string(source) {
+		return exitOK
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	if err := os.WriteFile(path, []byte(formatted), info.Mode().Perm()); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	return exitOK
+}
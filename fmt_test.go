@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormatFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "script.mk")
+	if err := os.WriteFile(path, []byte("let add=fn(a,b){a+b}\nadd(1,(2*3))"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expected := "let add = fn(a, b) {\n\ta + b\n};\nadd(1, 2 * 3);\n"
+
+	var stdout, stderr bytes.Buffer
+	if status := formatFile(path, false, &stdout, &stderr); status != exitOK || stdout.String() != expected {
+		t.Errorf("expected %q, got status %d and %q %q", expected, status, stdout.String(), stderr.String())
+	}
+
+	stdout.Reset()
+	if status := formatFile(path, true, &stdout, &stderr); status != exitOK || stdout.Len() != 0 {
+		t.Errorf("expected no output with -w, got status %d and %q %q", status, stdout.String(), stderr.String())
+	}
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != expected {
+		t.Errorf("expected the file to be rewritten to %q, got %q", expected, written)
+	}
+
+	if err := os.WriteFile(path, []byte("let x 1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	status := formatFile(path, true, &stdout, &stderr)
+	if status != exitError || stderr.String() != path+":1:7: expected next token to be =, got INT\n" {
+		t.Errorf("unexpected status %d and %q", status, stderr.String())
+	}
+}
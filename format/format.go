@@ -0,0 +1,324 @@
+// Package format prints Monkey programs in their canonical layout: one statement per line, blocks
+// indented with tabs and only the parentheses that the operator precedences require. The comments of a
+// program are kept: those between statements on their own lines and those within a statement after it.
+package format
+
+import (
+	"math"
+	"monkey/ast"
+	"monkey/token"
+	"strings"
+)
+
+// Source formats Monkey source code. Nothing is formatted if the source has syntax errors.
+func Source(source string) (string, []ast.ParseError) {
+	program, errs := ast.NewParser(token.NewLexer(source)).Parse()
+	if len(errs) != 0 {
+		return "", errs
+	}
+	return Node(program), nil
+}
+
+// Node prints node in the canonical layout. A program ends with a newline, other nodes do not.
+func Node(node ast.Node) string {
+	printer := &printer{}
+	switch node := node.(type) {
+	case *ast.Program:
+		printer.comments = node.Comments
+		printer.statements(node.Statements, false, math.MaxInt)
+	case ast.Statement:
+		printer.statement(node)
+	case ast.Expression:
+		printer.expression(node)
+	}
+	return printer.builder.String()
+}
+
+type printer struct {
+	builder  strings.Builder
+	indent   int
+	comments []token.Comment // the comments not printed yet
+	line     int             // the source line of the last statement or comment printed
+}
+
+func (printer *printer) write(s string) {
+	printer.builder.WriteString(s)
+}
+
+func (printer *printer) newline() {
+	printer.write("\n")
+	printer.write(strings.Repeat("\t", printer.indent))
+}
+
+// statements prints every statement and comment before end, the offset of the closing brace of a block,
+// on its own line and keeps a single blank line where the source had any.
+func (printer *printer) statements(stmts []ast.Statement, inBlock bool, end int) {
+	first := true
+	for i, stmt := range stmts {
+		printer.leadingComments(stmt.Pos().Offset, &first)
+		printer.separate(stmt.Pos().Line, &first)
+
+		printer.statement(stmt)
+		var next ast.Statement
+		boundary := end
+		if i < len(stmts)-1 {
+			next = stmts[i+1]
+			boundary = next.Pos().Offset
+		}
+		if needsSemicolon(stmt, next, inBlock) {
+			printer.write(";")
+		}
+		printer.line = endLine(stmt)
+		printer.trailingComments(boundary)
+	}
+	printer.leadingComments(end, &first)
+	if !inBlock && !first {
+		printer.write("\n")
+	}
+}
+
+// separate starts a new line unless this is the first line of a statement list.
+func (printer *printer) separate(line int, first *bool) {
+	if !*first {
+		if line > printer.line+1 {
+			printer.write("\n")
+		}
+		printer.newline()
+	}
+	*first = false
+}
+
+// leadingComments prints the comments before offset on their own lines, except that comments following
+// a block comment on its last line stay there.
+func (printer *printer) leadingComments(offset int, first *bool) {
+	var previous *token.Comment
+	for len(printer.comments) > 0 && printer.comments[0].Pos.Offset < offset {
+		comment := printer.comments[0]
+		printer.comments = printer.comments[1:]
+		if previous != nil && !previous.IsLine() && comment.Pos.Line == printer.line {
+			printer.write(" ")
+		} else {
+			printer.separate(comment.Pos.Line, first)
+		}
+		printer.write(comment.Text)
+		printer.line = comment.Pos.Line + strings.Count(comment.Text, "\n")
+		previous = &comment
+	}
+}
+
+// trailingComments appends the comments before offset that start within the statement just printed.
+func (printer *printer) trailingComments(offset int) {
+	afterLineComment := false
+	for len(printer.comments) > 0 && printer.comments[0].Pos.Offset < offset && printer.comments[0].Pos.Line <= printer.line {
+		comment := printer.comments[0]
+		printer.comments = printer.comments[1:]
+		if afterLineComment {
+			printer.newline()
+		} else {
+			printer.write(" ")
+		}
+		printer.write(comment.Text)
+		afterLineComment = comment.IsLine()
+		if end := comment.Pos.Line + strings.Count(comment.Text, "\n"); end > printer.line {
+			printer.line = end
+		}
+	}
+}
+
+func (printer *printer) statement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		printer.write("let ")
+		printer.write(stmt.Name.Value)
+		printer.write(" = ")
+		printer.expression(stmt.Value)
+	case *ast.ReturnStatement:
+		printer.write("return ")
+		printer.expression(stmt.ReturnValue)
+	case *ast.ExpressionStatement:
+		printer.expression(stmt.Expression)
+	case *ast.BlockStatement:
+		printer.block(stmt)
+	}
+}
+
+// needsSemicolon reports whether stmt must be terminated when followed by next, which is nil for the
+// last statement. Let and return statements always are. The value of a block is not and an if
+// expression only when next would otherwise continue it, e.g. as an operand or a call.
+func needsSemicolon(stmt ast.Statement, next ast.Statement, inBlock bool) bool {
+	exprStmt, ok := stmt.(*ast.ExpressionStatement)
+	if !ok {
+		return true
+	}
+	_, isIf := exprStmt.Expression.(*ast.IfExpression)
+	if next == nil {
+		return !inBlock && !isIf
+	}
+	if !isIf {
+		return true
+	}
+	first := token.NewLexer(Node(next)).NextToken()
+	_, continues := ast.Precedences[first.Type]
+	return continues
+}
+
+// endLine returns the last source line of stmt that is known from its tokens.
+func endLine(stmt ast.Statement) int {
+	line := 0
+	ast.Inspect(stmt, func(node ast.Node) bool {
+		if node == nil {
+			return false
+		}
+		if node.Pos().Line > line {
+			line = node.Pos().Line
+		}
+		if block, ok := node.(*ast.BlockStatement); ok && block.Rbrace != nil && block.Rbrace.Pos.Line > line {
+			line = block.Rbrace.Pos.Line
+		}
+		return true
+	})
+	return line
+}
+
+func (printer *printer) block(block *ast.BlockStatement) {
+	// a block without a closing brace from the source has no comments of its own
+	end := -1
+	if block.Rbrace != nil {
+		end = block.Rbrace.Pos.Offset
+	}
+	if len(block.Statements) == 0 && (len(printer.comments) == 0 || printer.comments[0].Pos.Offset >= end) {
+		printer.write("{}")
+		return
+	}
+	printer.write("{")
+	printer.indent++
+	printer.newline()
+	printer.statements(block.Statements, true, end)
+	printer.indent--
+	printer.newline()
+	printer.write("}")
+}
+
+func (printer *printer) expression(expr ast.Expression) {
+	switch expr := expr.(type) {
+	case *ast.Identifier:
+		printer.write(expr.Value)
+	case *ast.Integer:
+		printer.write(expr.Token.Literal)
+	case *ast.FloatLiteral:
+		printer.write(expr.Token.Literal)
+	case *ast.StringLiteral:
+		printer.write(ast.Quote(expr.Value))
+	case *ast.Boolean:
+		printer.write(expr.String())
+	case *ast.PrefixExpression:
+		printer.write(expr.Operator)
+		printer.operand(expr.Right, precedence(expr.Right) < ast.Prefix)
+	case *ast.InfixExpression:
+		// operators other than ** are left associative, so an operand of the same precedence on the other
+		// side keeps its parentheses
+		rightAssociative := expr.Token.Type == token.Power
+		printer.operand(expr.Left, precedence(expr.Left) < precedence(expr) ||
+			rightAssociative && precedence(expr.Left) == precedence(expr))
+		printer.write(" " + expr.Operator + " ")
+		printer.operand(expr.Right, precedence(expr.Right) < precedence(expr) ||
+			!rightAssociative && precedence(expr.Right) == precedence(expr))
+	case *ast.CallExpression:
+		printer.operand(expr.Function, precedence(expr.Function) < ast.Call)
+		printer.write("(")
+		printer.expressions(expr.Arguments)
+		printer.write(")")
+	case *ast.IndexExpression:
+		printer.operand(expr.Left, precedence(expr.Left) < ast.Call)
+		printer.write("[")
+		printer.expression(expr.Index)
+		printer.write("]")
+	case *ast.AssignExpression:
+		printer.expression(expr.Target)
+		printer.write(" " + expr.Operator + " ")
+		printer.expression(expr.Value)
+	case *ast.IfExpression:
+		printer.write("if (")
+		printer.expression(expr.Condition)
+		printer.write(") ")
+		printer.block(expr.Consequence)
+		if expr.Alternative != nil {
+			printer.write(" else ")
+			printer.block(expr.Alternative)
+		}
+	case *ast.Function:
+		printer.write("fn")
+		printer.params(expr.Params)
+		printer.block(expr.Body)
+	case *ast.MacroLiteral:
+		printer.write("macro")
+		printer.params(expr.Params)
+		printer.block(expr.Body)
+	case *ast.ArrayLiteral:
+		printer.write("[")
+		printer.expressions(expr.Elements)
+		printer.write("]")
+	case *ast.HashLiteral:
+		printer.write("{")
+		for i, pair := range expr.Pairs {
+			if i > 0 {
+				printer.write(", ")
+			}
+			printer.expression(pair.Key)
+			printer.write(": ")
+			printer.expression(pair.Value)
+		}
+		printer.write("}")
+	}
+}
+
+func (printer *printer) operand(expr ast.Expression, parenthesize bool) {
+	if parenthesize {
+		printer.write("(")
+		printer.expression(expr)
+		printer.write(")")
+	} else {
+		printer.expression(expr)
+	}
+}
+
+func (printer *printer) expressions(exprs []ast.Expression) {
+	for i, expr := range exprs {
+		if i > 0 {
+			printer.write(", ")
+		}
+		printer.expression(expr)
+	}
+}
+
+func (printer *printer) params(params []*ast.Identifier) {
+	printer.write("(")
+	for i, param := range params {
+		if i > 0 {
+			printer.write(", ")
+		}
+		printer.write(param.Value)
+	}
+	printer.write(") ")
+}
+
+// precedence returns how tightly expr binds: the precedence of its operator, Assignment for
+// assignments, Prefix for prefix expressions, Call for calls and indexing and Index for everything
+// that needs no operator.
+func precedence(expr ast.Expression) int {
+	switch expr := expr.(type) {
+	case *ast.InfixExpression:
+		if p, ok := ast.Precedences[expr.Token.Type]; ok {
+			return p
+		}
+		return ast.Lowest
+	case *ast.AssignExpression:
+		return ast.Assignment
+	case *ast.PrefixExpression:
+		return ast.Prefix
+	case *ast.CallExpression, *ast.IndexExpression:
+		return ast.Call
+	default:
+		return ast.Index
+	}
+}
@@ -0,0 +1,109 @@
+package format
+
+import (
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x=1", "let x = 1;\n"},
+		{"puts(1,2)", "puts(1, 2);\n"},
+		{"return (1)", "return 1;\n"},
+		{"", ""},
+
+		// parentheses
+		{"(1 + 2) * 3", "(1 + 2) * 3;\n"},
+		{"1 + (2 * 3)", "1 + 2 * 3;\n"},
+		{"(1 + 2) + 3", "1 + 2 + 3;\n"},
+		{"1 - (2 - 3)", "1 - (2 - 3);\n"},
+		{"1 + (2 + 3)", "1 + (2 + 3);\n"},
+		{"(a < b) == (c > d)", "a < b == c > d;\n"},
+		{"(a && b) || (c <= d)", "a && b || c <= d;\n"},
+		{"a && (b || c)", "a && (b || c);\n"},
+		{"2 ** (3 ** 2)", "2 ** 3 ** 2;\n"},
+		{"(2 ** 3) ** 2", "(2 ** 3) ** 2;\n"},
+		{"(-2) ** 2", "(-2) ** 2;\n"},
+		{"-(2 ** 2)", "-2 ** 2;\n"},
+		{"(a & b) | (c ^ (d << 1))", "a & b | c ^ d << 1;\n"},
+		{"~(a | b)", "~(a | b);\n"},
+		{"x = (y = 1 + 2)", "x = y = 1 + 2;\n"},
+		{"(x = 1) * 2", "(x = 1) * 2;\n"},
+		{"a[0]+=b||c", "a[0] += b || c;\n"},
+		{"!(a && b)", "!(a && b);\n"},
+		{"a == (b == c)", "a == (b == c);\n"},
+		{"-(1 + 2)", "-(1 + 2);\n"},
+		{"(-a) * b", "-a * b;\n"},
+		{"!(-a)", "!-a;\n"},
+		{"-(-a)", "--a;\n"},
+		{"(f)(1)", "f(1);\n"},
+		{"(-f)(1)", "(-f)(1);\n"},
+		{"(a + b)[0]", "(a + b)[0];\n"},
+		{"(f(1))[0](2)", "f(1)[0](2);\n"},
+		{"-(a[0])", "-a[0];\n"},
+		{"(fn(x) { x })(1)", "fn(x) {\n\tx\n}(1);\n"},
+
+		// literals
+		{`["a",true,  {"b":[1]} ]`, `["a", true, {"b": [1]}];` + "\n"},
+		{"{}", "{};\n"},
+		{"1.50 * 2e3", "1.50 * 2e3;\n"},
+		{`"tab\there \"q\" \u{1F600}"`, `"tab\there \"q\" ` + "\U0001F600" + `";` + "\n"},
+
+		// blocks
+		{"fn(){}", "fn() {};\n"},
+		{"let f = fn(a,b) { let c = a + b; c }", "let f = fn(a, b) {\n\tlet c = a + b;\n\tc\n};\n"},
+		{"fn(a) { return a; }", "fn(a) {\n\treturn a;\n};\n"},
+		{"if (a) { 1 } else { if (b) { 2 } }",
+			"if (a) {\n\t1\n} else {\n\tif (b) {\n\t\t2\n\t}\n}\n"},
+		{"if (a) { b }; c", "if (a) {\n\tb\n}\nc;\n"},
+		{"if (a) { b }; (c)(1)", "if (a) {\n\tb\n}\nc(1);\n"},
+		{"if (a) { b }; (c + d) * 2", "if (a) {\n\tb\n};\n(c + d) * 2;\n"},
+		{"if (a) { b }; -c", "if (a) {\n\tb\n};\n-c;\n"},
+		{"if (a) { b }; [1]", "if (a) {\n\tb\n};\n[1];\n"},
+		{"let m = macro(x) { quote(unquote(x) + 1) }",
+			"let m = macro(x) {\n\tquote(unquote(x) + 1)\n};\n"},
+
+		// blank lines
+		{"let a = 1;\n\n\n\nlet b = 2;\nlet c = 3;", "let a = 1;\n\nlet b = 2;\nlet c = 3;\n"},
+		{"let f = fn() {\n  1\n};\nf()", "let f = fn() {\n\t1\n};\nf();\n"},
+		{"let f = fn() {\n  1\n};\n\nf()", "let f = fn() {\n\t1\n};\n\nf();\n"},
+		{"let f = fn() {\n\n  let a = 1;\n\n  a\n}", "let f = fn() {\n\tlet a = 1;\n\n\ta\n};\n"},
+
+		// comments
+		{"// header\nlet a = 1; // one\n\n/* two */\nlet b = 2", "// header\nlet a = 1; // one\n\n/* two */\nlet b = 2;\n"},
+		{"let a = [1, // one\n2]; /* end */ // a", "let a = [1, 2]; // one\n/* end */ // a\n"},
+		{"a; // x\n// y", "a; // x\n// y\n"},
+		{"let f = fn() { // start\n  1 // value\n  // last\n}", "let f = fn() {\n\t// start\n\t1 // value\n\t// last\n};\n"},
+		{"fn() { /* empty */ }", "fn() {\n\t/* empty */\n};\n"},
+		{"if (a) { 1 } // c\nelse { 2 }", "if (a) {\n\t1\n} else {\n\t// c\n\t2\n}\n"},
+		{"/* only */", "/* only */\n"},
+		{"/* a */ /* b */ // c\nx", "/* a */ /* b */ // c\nx;\n"},
+		{"1 +\n/* multi\n line */ 2;\n3", "1 + 2; /* multi\n line */\n3;\n"},
+	}
+
+	for _, tt := range tests {
+		formatted, errs := Source(tt.input)
+		if len(errs) != 0 {
+			t.Errorf("%q: unexpected errors %v", tt.input, errs)
+			continue
+		}
+		if formatted != tt.expected {
+			t.Errorf("%q: expected\n%s\ngot\n%s", tt.input, tt.expected, formatted)
+			continue
+		}
+
+		again, _ := Source(formatted)
+		if again != formatted {
+			t.Errorf("%q: formatting is not idempotent, got\n%s", tt.input, again)
+		}
+	}
+}
+
+func TestSourceErrors(t *testing.T) {
+	formatted, errs := Source("let x 1")
+	if len(errs) != 1 || formatted != "" {
+		t.Errorf("expected one error and no output, got %v and %q", errs, formatted)
+	}
+}
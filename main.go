@@ -1,5 +1,77 @@
 package main
 
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+const usage = `usage:
+  monkey [flags]                      start an interactive session
+  monkey [flags] run <file>           execute a script or a compiled .mkc file,
+                                      "-" reads it from standard input
+  monkey build [-o <output>] <file>   compile a script to a .mkc file
+  monkey fmt [-w] <file>              print a script in the canonical layout,
+                                      -w rewrites the file instead
+
+flags:
+  --engine=eval|vm  execute with the tree-walking evaluator (default) or the bytecode VM,
+                    compiled .mkc files always run on the VM
+  --compare         execute with both engines and report any divergence,
+                    "run" exits with status 3 if they diverge`
+
 func main() {
+	flags := flag.NewFlagSet("monkey", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, usage) }
+	engineName := flags.String("engine", "eval", "")
+	compare := flags.Bool("compare", false, "")
+	flags.Parse(os.Args[1:])
+
+	args := flags.Args()
+	if len(args) == 0 {
+		engine, err := newEngine(*engineName, *compare, os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+		startREPL(os.Stdin, os.Stdout, engine)
+		return
+	}
 
+	switch args[0] {
+	case "run":
+		if len(args) != 2 {
+			flags.Usage()
+			os.Exit(exitUsage)
+		}
+		engine, err := newEngine(*engineName, *compare, os.Stderr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+		os.Exit(runFile(args[1], os.Stderr, engine))
+	case "build":
+		buildFlags := flag.NewFlagSet("build", flag.ExitOnError)
+		buildFlags.Usage = flags.Usage
+		output := buildFlags.String("o", "", "")
+		buildFlags.Parse(args[1:])
+		if buildFlags.NArg() != 1 {
+			flags.Usage()
+			os.Exit(exitUsage)
+		}
+		os.Exit(buildFile(buildFlags.Arg(0), *output, os.Stderr))
+	case "fmt":
+		fmtFlags := flag.NewFlagSet("fmt", flag.ExitOnError)
+		fmtFlags.Usage = flags.Usage
+		write := fmtFlags.Bool("w", false, "")
+		fmtFlags.Parse(args[1:])
+		if fmtFlags.NArg() != 1 {
+			flags.Usage()
+			os.Exit(exitUsage)
+		}
+		os.Exit(formatFile(fmtFlags.Arg(0), *write, os.Stdout, os.Stderr))
+	default:
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
 }
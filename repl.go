@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/eval"
+	"monkey/token"
+	"strings"
+)
+
+const (
+	prompt             = ">> "
+	continuationPrompt = ".. "
+)
+
+// startREPL reads programs from in line by line and runs them on engine, which keeps the global state.
+// Input with unclosed parentheses or braces is continued on the next line.
+func startREPL(in io.Reader, out io.Writer, engine engine) {
+	scanner := bufio.NewScanner(in)
+	macroEnv := eval.NewEnvironment()
+
+	var buffer strings.Builder
+	for {
+		if buffer.Len() == 0 {
+			fmt.Fprint(out, prompt)
+		} else {
+			fmt.Fprint(out, continuationPrompt)
+		}
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+
+		buffer.WriteString(scanner.Text())
+		buffer.WriteString("\n")
+		input := buffer.String()
+		if isIncomplete(input) {
+			continue
+		}
+		buffer.Reset()
+
+		program, errs := ast.NewParser(token.NewLexer(input)).Parse()
+		if len(errs) != 0 {
+			for _, err := range errs {
+				fmt.Fprintln(out, err)
+			}
+			continue
+		}
+
+		program, err := expandMacros(program, macroEnv)
+		if err != nil {
+			fmt.Fprintln(out, err)
+			continue
+		}
+
+		result := engine.Run(program)
+		if err, ok := result.(*eval.ErrorObject); ok {
+			if err.Pos.Line != 0 {
+				fmt.Fprintf(out, "%s: ", err.Pos)
+			}
+			fmt.Fprintf(out, "%s\n%s", err.Inspect(), err.StackTrace())
+		} else if result != nil {
+			fmt.Fprintln(out, result.Inspect())
+		}
+	}
+}
+
+// isIncomplete reports whether input has more opening than closing delimiters or ends in an open block comment.
+func isIncomplete(input string) bool {
+	depth := 0
+	lexer := token.NewLexer(input)
+	for tok := lexer.NextToken(); tok.Type != token.Eof; tok = lexer.NextToken() {
+		switch tok.Type {
+		case token.Illegal:
+			if strings.HasPrefix(tok.Literal, "/*") {
+				return true
+			}
+		case token.Lparen, token.Lbrace, token.Lbracket:
+			depth++
+		case token.Rparen, token.Rbrace, token.Rbracket:
+			depth--
+		}
+	}
+	return depth > 0
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestREPL(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+}
+add(1,
+2)
+let = 1;
+add(3, 4) * 2 /* spans
+lines */ // done
+`
+	var out bytes.Buffer
+	startREPL(strings.NewReader(input), &out, newEvalEngine())
+
+	expected := ">> .. .. >> .. 3\n>> 1:5: expected next token to be IDENT, got =\n>> .. 14\n>> \n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestREPLDiscardsFailedCompilation(t *testing.T) {
+	// a must not stay defined, and unbound, after its let statement fails to compile
+	input := "let a = fn() { len = 1 }\na()\nlet b = 2\nb\n"
+	var out bytes.Buffer
+	startREPL(strings.NewReader(input), &out, newVMEngine())
+
+	expected := ">> 1:20: ERROR: cannot assign to builtin: len\n>> 1:1: ERROR: identifier not found: a\n>> >> 2\n>> \n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
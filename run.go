@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/eval"
+	"monkey/token"
+	"os"
+	"path/filepath"
+)
+
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitDiverged = 3
+)
+
+// runFile executes the script at path, or standard input if path is "-", and returns the exit status.
+// Compiled .mkc files are recognized by their header and always run on the VM. Scripts are lexed as
+// they are read.
+func runFile(path string, stderr io.Writer, engine engine) int {
+	var file io.Reader = os.Stdin
+	if path == "-" {
+		path = "<stdin>"
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+		defer f.Close()
+		file = f
+	}
+
+	reader := bufio.NewReader(file)
+	header, _ := reader.Peek(len(compiler.FileMagic))
+	if compiler.IsBytecode(header) || filepath.Ext(path) == ".mkc" {
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+		return runBytecode(path, data, stderr)
+	}
+	return runSource(path, reader, stderr, engine)
+}
+
+func runBytecode(name string, data []byte, stderr io.Writer) int {
+	bytecode, source, err := compiler.Decode(bytes.NewReader(data))
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", name, err)
+		return exitError
+	}
+	if source == "" {
+		source = name
+	}
+	return reportResult(source, newVMEngine().RunBytecode(bytecode), stderr)
+}
+
+func runSource(name string, source io.Reader, stderr io.Writer, engine engine) int {
+	lexer := token.NewReaderLexer(source)
+	program, errs := ast.NewParser(lexer).Parse()
+	if lexer.Err() != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", name, lexer.Err())
+		return exitError
+	}
+	if len(errs) != 0 {
+		for _, err := range errs {
+			fmt.Fprintf(stderr, "%s:%s\n", name, err)
+		}
+		return exitError
+	}
+
+	program, err := expandMacros(program, eval.NewEnvironment())
+	if err != nil {
+		fmt.Fprintf(stderr, "%s:%s\n", name, err)
+		return exitError
+	}
+
+	status := reportResult(name, engine.Run(program), stderr)
+	if compare, ok := engine.(*compareEngine); ok && compare.diverged {
+		return exitDiverged
+	}
+	return status
+}
+
+// expandMacros moves the macro definitions of program into macroEnv and expands the calls to them,
+// which must happen before the program is evaluated or compiled.
+func expandMacros(program *ast.Program, macroEnv *eval.Environment) (*ast.Program, error) {
+	eval.DefineMacros(program, macroEnv)
+	return eval.ExpandMacros(program, macroEnv)
+}
+
+// reportResult prints a runtime error with its trace, name being the source file the positions refer to.
+func reportResult(name string, result eval.Object, stderr io.Writer) int {
+	if err, ok := result.(*eval.ErrorObject); ok {
+		location := name
+		if err.Pos.Line != 0 {
+			location += ":" + err.Pos.String()
+		}
+		fmt.Fprintf(stderr, "%s: runtime error: %s\n", location, err.Message)
+		for _, frame := range err.Trace {
+			fmt.Fprintf(stderr, "\tin %s called at %s:%s\n", frame.Function, name, frame.Pos)
+		}
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/eval"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunSource(t *testing.T) {
+	tests := []struct {
+		source   string
+		status   int
+		expected string
+	}{
+		{"let a = 1; a + 1", exitOK, ""},
+		{"let a = 1;\nlet b 2;", exitError, "test.mk:2:7: expected next token to be =, got INT\n"},
+		{"let a = 1; a + true", exitError, "test.mk:1:14: runtime error: type mismatch: INTEGER + BOOLEAN\n"},
+		{`let 名 = "é"; 名 + 1`, exitError, "test.mk:1:16: runtime error: type mismatch: STRING + INTEGER\n"},
+		{"let f = fn(x) {\n  x()\n};\nlet g = fn() { f(1) };\ng()", exitError,
+			"test.mk:2:3: runtime error: not a function: INTEGER\n" +
+				"\tin f called at test.mk:4:16\n" +
+				"\tin g called at test.mk:5:1\n"},
+	}
+
+	for _, tt := range tests {
+		var stderr bytes.Buffer
+		status := runSource("test.mk", strings.NewReader(tt.source), &stderr, newEvalEngine())
+		if status != tt.status {
+			t.Errorf("%q: expected status %d, got %d", tt.source, tt.status, status)
+		}
+		if stderr.String() != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.source, tt.expected, stderr.String())
+		}
+	}
+}
+
+func TestRunSourceEngines(t *testing.T) {
+	tests := []struct {
+		engine   string
+		compare  bool
+		source   string
+		status   int
+		expected string
+	}{
+		{"vm", false, "let f = fn(x) { x * 2 }; f(21)", exitOK, ""},
+		{"vm", false, "1 + true", exitError, "test.mk:1:3: runtime error: type mismatch: INTEGER + BOOLEAN\n"},
+		{"eval", true, "let f = fn(x) { x * 2 }; f(21)", exitOK, ""},
+		{"eval", true, "let unless = macro(c, a, b) { quote(if (!(unquote(c))) { unquote(a) } else { unquote(b) }) };\n" +
+			"unless(1 > 2, 1, 1 + true)", exitOK, ""},
+		{"vm", false, "let m = macro() { 1 };\nm()", exitError, "test.mk:2:1: macro m must return a quote, got INTEGER\n"},
+		{"eval", true, "let a = fn() { b() }; let b = fn() { 1 }; a()", exitOK, ""},
+		{"eval", true, "quote(1 + 2)", exitDiverged,
+			"divergence: eval returned QUOTE((1+2)), vm returned ERROR: identifier not found: quote\n"},
+		{"eval", true, "if (false) { undefined }; let f = fn() { g }; f()", exitError,
+			"test.mk:1:42: runtime error: identifier not found: g\n\tin f called at test.mk:1:47\n"},
+	}
+
+	for _, tt := range tests {
+		var stderr bytes.Buffer
+		engine, err := newEngine(tt.engine, tt.compare, &stderr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status := runSource("test.mk", strings.NewReader(tt.source), &stderr, engine)
+		if status != tt.status {
+			t.Errorf("%q: expected status %d, got %d", tt.source, tt.status, status)
+		}
+		if stderr.String() != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.source, tt.expected, stderr.String())
+		}
+	}
+}
+
+func TestBuildAndRunBytecode(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "script.mk")
+	if err := os.WriteFile(source, []byte("let f = fn(x) {\n  x + true\n};\nf(1)"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr bytes.Buffer
+	if status := buildFile(source, "", &stderr); status != exitOK {
+		t.Fatalf("build failed: %s", stderr.String())
+	}
+
+	status := runFile(filepath.Join(dir, "script.mkc"), &stderr, newEvalEngine())
+	expected := source + ":2:5: runtime error: type mismatch: INTEGER + BOOLEAN\n\tin f called at " + source + ":4:1\n"
+	if status != exitError || stderr.String() != expected {
+		t.Errorf("expected %q, got status %d and %q", expected, status, stderr.String())
+	}
+
+	stderr.Reset()
+	if err := os.WriteFile(filepath.Join(dir, "bad.mkc"), []byte("let a = 1;"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	status = runFile(filepath.Join(dir, "bad.mkc"), &stderr, newEvalEngine())
+	if status != exitError || !strings.HasSuffix(stderr.String(), "bad.mkc: not a Monkey bytecode file\n") {
+		t.Errorf("unexpected status %d and %q", status, stderr.String())
+	}
+}
+
+func TestBuildWriteError(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full to fail writes")
+	}
+	source := filepath.Join(t.TempDir(), "script.mk")
+	if err := os.WriteFile(source, []byte("1 + 2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr bytes.Buffer
+	if status := buildFile(source, "/dev/full", &stderr); status != exitError || !strings.Contains(stderr.String(), "/dev/full") {
+		t.Errorf("unexpected status %d and %q", status, stderr.String())
+	}
+}
+
+func TestRunInvalidBytecode(t *testing.T) {
+	// instructions that pass validation but misuse the stack, which only running them can tell
+	tests := [][]byte{
+		code.Make(code.OpPop),
+		append(code.Make(code.OpTrue), code.Make(code.OpGetCell)...),
+		append(code.Make(code.OpClosure, 0, 0), code.Make(code.OpCall, 0)...),
+	}
+	function := &eval.CompiledFunctionObject{Instructions: append(code.Make(code.OpGetFree, 3), code.Make(code.OpReturnValue)...)}
+
+	dir := t.TempDir()
+	for i, instructions := range tests {
+		var buffer bytes.Buffer
+		bytecode := &compiler.Bytecode{Instructions: instructions, Constants: []eval.Object{function}}
+		if err := compiler.Encode(&buffer, bytecode, "bad.mk"); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "bad.mkc")
+		if err := os.WriteFile(path, buffer.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		var stderr bytes.Buffer
+		status := runFile(path, &stderr, newEvalEngine())
+		if status != exitError || !strings.HasPrefix(stderr.String(), "bad.mk: runtime error: invalid bytecode: ") {
+			t.Errorf("test %d: unexpected status %d and %q", i, status, stderr.String())
+		}
+	}
+}
@@ -1,27 +1,118 @@
 package token
 
+import (
+    "io"
+    "strconv"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+// Lexer splits UTF-8 encoded source into tokens. The source is either a string or read incrementally
+// from an io.Reader into buf, which starts at the byte offset base of the source and keeps everything
+// from mark, the start of the current token or comment, on. pos is the byte offset of the current
+// rune char and toReadPos the offset of the next one. column counts runes, not bytes, and lineStart
+// is the offset of the first byte of the current line.
 type Lexer struct {
-    input     string
+    reader io.Reader
+    buf    []byte
+    base   int
+    mark   int
+    err    error
+
     pos       int
     toReadPos int
-    char      byte
+    char      rune
+    line      int
+    column    int
+    lineStart int
+
+    previous *Token
+    comments []Comment
 }
 
+// readSize is the minimum number of bytes requested from the reader at once.
+const readSize = 4096
+
 func NewLexer(input string) *Lexer {
-    lexer := &Lexer{input: input}
+    lexer := &Lexer{buf: []byte(input), line: 1}
+    lexer.readChar()
+
+    return lexer
+}
+
+// NewReaderLexer returns a lexer that reads the source from reader as the tokens are requested,
+// buffering only the current token. A read error ends the source early and is reported by Err.
+func NewReaderLexer(reader io.Reader) *Lexer {
+    lexer := &Lexer{reader: reader, line: 1}
     lexer.readChar()
 
     return lexer
 }
 
 func (lexer *Lexer) HasNext() bool {
-    return lexer.pos != len(lexer.input)
+    return lexer.pos < lexer.end()
+}
+
+// Err returns the first error other than io.EOF that occurred while reading the source.
+func (lexer *Lexer) Err() error {
+    return lexer.err
+}
+
+// Comments returns every comment read so far in source order.
+func (lexer *Lexer) Comments() []Comment {
+    return lexer.comments
 }
 
 func (lexer *Lexer) NextToken() *Token {
-    lexer.skipWhitespaces()
+    leading, token := lexer.skipTrivia()
+    if token == nil {
+        lexer.mark = lexer.pos
+        token = lexer.nextToken()
+    }
+    token.Leading = leading
+    lexer.previous = token
+    return token
+}
+
+// end returns the offset just after the buffered source.
+func (lexer *Lexer) end() int {
+    return lexer.base + len(lexer.buf)
+}
+
+// fill reads from the reader until the source up to offset is buffered or the source ends.
+// Bytes before mark are dropped to make room.
+func (lexer *Lexer) fill(offset int) {
+    for lexer.reader != nil && lexer.end() < offset {
+        if drop := lexer.mark - lexer.base; drop > 0 {
+            lexer.buf = append(lexer.buf[:0], lexer.buf[drop:]...)
+            lexer.base = lexer.mark
+        }
+        if cap(lexer.buf)-len(lexer.buf) < readSize {
+            buf := make([]byte, len(lexer.buf), 2*cap(lexer.buf)+readSize)
+            copy(buf, lexer.buf)
+            lexer.buf = buf
+        }
 
+        n, err := lexer.reader.Read(lexer.buf[len(lexer.buf):cap(lexer.buf)])
+        lexer.buf = lexer.buf[:len(lexer.buf)+n]
+        if err != nil {
+            if err != io.EOF {
+                lexer.err = err
+            }
+            lexer.reader = nil
+        }
+    }
+}
+
+// text returns the source between the offsets from and to, which must not be before mark.
+func (lexer *Lexer) text(from, to int) string {
+    return string(lexer.buf[from-lexer.base : to-lexer.base])
+}
+
+func (lexer *Lexer) nextToken() *Token {
     var token *Token
+    pos := lexer.position()
     currentChar := lexer.char
     switch currentChar {
     case '=':
@@ -35,6 +126,8 @@ func (lexer *Lexer) NextToken() *Token {
         token = newToken(Semicolon, ";")
     case ',':
         token = newToken(Comma, ",")
+    case ':':
+        token = newToken(Colon, ":")
     case '(':
         token = newToken(Lparen, "(")
     case ')':
@@ -43,10 +136,24 @@ func (lexer *Lexer) NextToken() *Token {
         token = newToken(Lbrace, "{")
     case '}':
         token = newToken(Rbrace, "}")
+    case '[':
+        token = newToken(Lbracket, "[")
+    case ']':
+        token = newToken(Rbracket, "]")
     case '+':
-        token = newToken(Plus, "+")
+        if lexer.peekChar() == '=' {
+            lexer.readChar()
+            token = newToken(PlusEq, "+=")
+        } else {
+            token = newToken(Plus, "+")
+        }
     case '-':
-        token = newToken(Minus, "-")
+        if lexer.peekChar() == '=' {
+            lexer.readChar()
+            token = newToken(MinusEq, "-=")
+        } else {
+            token = newToken(Minus, "-")
+        }
     case '!':
         if lexer.peekChar() == '=' {
             lexer.readChar()
@@ -55,13 +162,72 @@ func (lexer *Lexer) NextToken() *Token {
             token = newToken(Bang, "!")
         }
     case '*':
-        token = newToken(Asterisk, "*")
+        switch lexer.peekChar() {
+        case '*':
+            lexer.readChar()
+            token = newToken(Power, "**")
+        case '=':
+            lexer.readChar()
+            token = newToken(TimesEq, "*=")
+        default:
+            token = newToken(Asterisk, "*")
+        }
     case '/':
-        token = newToken(Slash, "/")
+        if lexer.peekChar() == '=' {
+            lexer.readChar()
+            token = newToken(DivideEq, "/=")
+        } else {
+            token = newToken(Slash, "/")
+        }
+    case '%':
+        token = newToken(Percent, "%")
     case '<':
-        token = newToken(Lt, "<")
+        switch lexer.peekChar() {
+        case '=':
+            lexer.readChar()
+            token = newToken(Le, "<=")
+        case '<':
+            lexer.readChar()
+            token = newToken(Shl, "<<")
+        default:
+            token = newToken(Lt, "<")
+        }
     case '>':
-        token = newToken(Gt, ">")
+        switch lexer.peekChar() {
+        case '=':
+            lexer.readChar()
+            token = newToken(Ge, ">=")
+        case '>':
+            lexer.readChar()
+            token = newToken(Shr, ">>")
+        default:
+            token = newToken(Gt, ">")
+        }
+    case '&':
+        if lexer.peekChar() == '&' {
+            lexer.readChar()
+            token = newToken(And, "&&")
+        } else {
+            token = newToken(BitAnd, "&")
+        }
+    case '|':
+        if lexer.peekChar() == '|' {
+            lexer.readChar()
+            token = newToken(Or, "||")
+        } else {
+            token = newToken(BitOr, "|")
+        }
+    case '^':
+        token = newToken(BitXor, "^")
+    case '~':
+        token = newToken(BitNot, "~")
+    case '"':
+        start := lexer.pos
+        if value, ok := lexer.readString(); ok {
+            token = newToken(String, value)
+        } else {
+            token = newToken(Illegal, lexer.text(start, lexer.pos))
+        }
     case 0:
         token = newToken(Eof, "")
     default:
@@ -71,58 +237,265 @@ func (lexer *Lexer) NextToken() *Token {
             if ok, tokenType := isKeyword(word); ok {
                 token.Type = tokenType
             }
+            token.Pos = pos
             return token
         } else if isDigit(currentChar) {
-            number := lexer.readNumber()
-            token = newToken(Int, number)
+            token = lexer.readNumber()
+            token.Pos = pos
             return token
         } else {
-            token = newToken(Illegal, "")
+            // the bytes of the rune, which need not be valid UTF-8
+            token = newToken(Illegal, lexer.text(lexer.pos, lexer.toReadPos))
         }
     }
 
+    token.Pos = pos
     lexer.readChar()
     return token
 }
 
-func (lexer *Lexer) readChar() byte {
-    if lexer.toReadPos < len(lexer.input) {
-        lexer.char = lexer.input[lexer.toReadPos]
-        lexer.pos = lexer.toReadPos
-        lexer.toReadPos++
-    } else {
-        lexer.char = 0
-        lexer.pos = lexer.toReadPos
+// readChar decodes the next rune. An invalid UTF-8 byte is read as utf8.RuneError of width 1.
+func (lexer *Lexer) readChar() rune {
+    newline := lexer.char == '\n'
+    if newline {
+        lexer.line++
+        lexer.column = 0
+    }
+    lexer.column++
+    lexer.pos = lexer.toReadPos
+    if newline {
+        lexer.lineStart = lexer.pos
+    }
+    lexer.char = lexer.decode(lexer.pos)
+    if lexer.pos < lexer.end() {
+        _, width := utf8.DecodeRune(lexer.buf[lexer.pos-lexer.base:])
+        lexer.toReadPos += width
     }
     return lexer.char
 }
 
-func (lexer *Lexer) peekChar() byte {
-    if lexer.HasNext() {
-        return lexer.input[lexer.toReadPos]
-    } else {
+// decode returns the rune at offset, or 0 at the end of the source.
+func (lexer *Lexer) decode(offset int) rune {
+    lexer.fill(offset + utf8.UTFMax)
+    if offset >= lexer.end() {
         return 0
     }
+    r, _ := utf8.DecodeRune(lexer.buf[offset-lexer.base:])
+    return r
+}
+
+func (lexer *Lexer) position() Position {
+    return Position{Offset: lexer.pos, Line: lexer.line, Column: lexer.column, ByteColumn: lexer.pos - lexer.lineStart + 1}
+}
+
+func (lexer *Lexer) peekChar() rune {
+    return lexer.decode(lexer.toReadPos)
 }
 
+// readWord reads an identifier or keyword: a letter or underscore followed by letters, underscores and digits.
 func (lexer *Lexer) readWord() string {
     pos := lexer.pos
-    for isLetter(lexer.char) {
+    for isLetter(lexer.char) || unicode.IsDigit(lexer.char) {
         lexer.readChar()
     }
-    return lexer.input[pos:lexer.pos]
+    return lexer.text(pos, lexer.pos)
 }
 
-func (lexer *Lexer) readNumber() string {
+// readNumber reads an integer or a float. Integers may have a base prefix (0xFF, 0o755, 0b1010),
+// floats a fraction (1.5) or an exponent (1e-3, 2.5E+10), and digits may be separated by single
+// underscores (1_000_000). An integer other than 0 cannot start with a zero, since 0755 would read as
+// octal in C. A malformed literal is read up to its last letter or digit and is illegal.
+func (lexer *Lexer) readNumber() *Token {
     pos := lexer.pos
-    for isDigit(lexer.char) {
+    leadingZero := false
+    if lexer.char == '0' {
+        if base := basePrefix(lexer.peekChar()); base != 0 {
+            lexer.readChar()
+            lexer.readChar()
+            valid := lexer.readDigits(base, true)
+            return lexer.numberToken(Int, pos, valid)
+        }
+        leadingZero = isDigit(lexer.peekChar()) || lexer.peekChar() == '_'
+    }
+
+    tokenType := Int
+    valid := lexer.readDigits(10, false)
+    if lexer.char == '.' && isDigit(lexer.peekChar()) {
+        tokenType = Float
+        lexer.readChar()
+        valid = lexer.readDigits(10, false) && valid
+    }
+    if lexer.char == 'e' || lexer.char == 'E' {
+        tokenType = Float
+        lexer.readChar()
+        if lexer.char == '+' || lexer.char == '-' {
+            lexer.readChar()
+        }
+        valid = lexer.readDigits(10, false) && valid
+    }
+    if tokenType == Int && leadingZero {
+        valid = false
+    }
+    return lexer.numberToken(tokenType, pos, valid)
+}
+
+// numberToken ends a number literal starting at pos. Letters, digits or underscores directly
+// after it, as in 0b102 or 12ab, make the whole literal illegal.
+func (lexer *Lexer) numberToken(tokenType Type, pos int, valid bool) *Token {
+    for isLetter(lexer.char) || isDigit(lexer.char) || lexer.char == '_' {
+        valid = false
+        lexer.readChar()
+    }
+    if !valid {
+        return newToken(Illegal, lexer.text(pos, lexer.pos))
+    }
+    return newToken(tokenType, lexer.text(pos, lexer.pos))
+}
+
+// readDigits reads digits of base separated by single underscores and reports whether there was at
+// least one digit. afterPrefix allows an underscore before the first digit, as in 0x_FF.
+func (lexer *Lexer) readDigits(base int, afterPrefix bool) bool {
+    digits := 0
+    underscore := false
+    if afterPrefix && lexer.char == '_' {
+        underscore = true
+        lexer.readChar()
+    }
+    for {
+        if lexer.char == '_' {
+            if underscore || digits == 0 {
+                return false
+            }
+            underscore = true
+        } else if isDigitOf(lexer.char, base) {
+            underscore = false
+            digits++
+        } else {
+            return digits > 0 && !underscore
+        }
+        lexer.readChar()
+    }
+}
+
+// readString reads a string literal starting at the opening quote and resolves its escape sequences.
+// It stops at the closing quote and reports false on an unterminated string or a bad escape.
+func (lexer *Lexer) readString() (string, bool) {
+    var builder strings.Builder
+    valid := true
+    for {
         lexer.readChar()
+        switch lexer.char {
+        case '"':
+            return builder.String(), valid
+        case 0:
+            return "", false
+        case '\\':
+            lexer.readChar()
+            switch lexer.char {
+            case 'n':
+                builder.WriteByte('\n')
+            case 't':
+                builder.WriteByte('\t')
+            case '"':
+                builder.WriteByte('"')
+            case '\\':
+                builder.WriteByte('\\')
+            case 'u':
+                r, ok := lexer.readUnicodeEscape()
+                if !ok {
+                    valid = false
+                }
+                builder.WriteRune(r)
+            case 0:
+                return "", false
+            default:
+                valid = false
+            }
+        default:
+            builder.WriteString(lexer.text(lexer.pos, lexer.toReadPos))
+        }
+    }
+}
+
+// readUnicodeEscape reads the {XXXX} part of a \u{XXXX} escape, with 1 to 6 hex digits.
+func (lexer *Lexer) readUnicodeEscape() (rune, bool) {
+    if lexer.peekChar() != '{' {
+        return 0, false
+    }
+    lexer.readChar()
+
+    var r rune
+    digits := 0
+    for isHexDigit(lexer.peekChar()) {
+        lexer.readChar()
+        value, _ := strconv.ParseInt(string(lexer.char), 16, 32)
+        r = r*16 + rune(value)
+        digits++
+    }
+    if digits == 0 || digits > 6 || lexer.peekChar() != '}' || !utf8.ValidRune(r) {
+        return 0, false
+    }
+    lexer.readChar()
+    return r, true
+}
+
+// skipTrivia skips whitespace and comments. Comments starting on the line of the previous token are
+// attached to it as trailing trivia, the others are returned as leading trivia of the next token.
+// An unterminated block comment is returned as an illegal token.
+func (lexer *Lexer) skipTrivia() ([]Comment, *Token) {
+    var leading []Comment
+    sameLine := lexer.previous != nil
+    for {
+        lexer.mark = lexer.pos
+        switch {
+        case lexer.char == '\n':
+            sameLine = false
+            lexer.readChar()
+        case lexer.char == ' ' || lexer.char == '\t' || lexer.char == '\r':
+            lexer.readChar()
+        case lexer.char == '/' && (lexer.peekChar() == '/' || lexer.peekChar() == '*'):
+            comment, ok := lexer.readComment()
+            if !ok {
+                token := newToken(Illegal, comment.Text)
+                token.Pos = comment.Pos
+                return leading, token
+            }
+            lexer.comments = append(lexer.comments, comment)
+            if sameLine {
+                lexer.previous.Trailing = append(lexer.previous.Trailing, comment)
+            } else {
+                leading = append(leading, comment)
+            }
+            if strings.Contains(comment.Text, "\n") {
+                sameLine = false
+            }
+        default:
+            return leading, nil
+        }
     }
-    return lexer.input[pos:lexer.pos]
 }
 
-func (lexer *Lexer) skipWhitespaces() {
-    for lexer.char == ' ' || lexer.char == '\t' || lexer.char == '\r' || lexer.char == '\n' {
+// readComment reads a comment starting at its first slash. It reports false for a block comment
+// that is not terminated before the end of the source.
+func (lexer *Lexer) readComment() (Comment, bool) {
+    pos := lexer.position()
+    if lexer.peekChar() == '/' {
+        for lexer.char != '\n' && lexer.char != 0 {
+            lexer.readChar()
+        }
+        return Comment{Text: strings.TrimRight(lexer.text(pos.Offset, lexer.pos), "\r"), Pos: pos}, true
+    }
+
+    lexer.readChar()
+    for {
         lexer.readChar()
+        switch {
+        case lexer.char == 0:
+            return Comment{Text: lexer.text(pos.Offset, lexer.pos), Pos: pos}, false
+        case lexer.char == '*' && lexer.peekChar() == '/':
+            lexer.readChar()
+            lexer.readChar()
+            return Comment{Text: lexer.text(pos.Offset, lexer.pos), Pos: pos}, true
+        }
     }
 }
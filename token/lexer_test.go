@@ -1,6 +1,13 @@
 package token
 
-import "testing"
+import (
+    "errors"
+    "fmt"
+    "io"
+    "strings"
+    "testing"
+    "testing/iotest"
+)
 
 var input = `
 ###
@@ -14,3 +21,252 @@ func Test(t *testing.T) {
         println(lexer.NextToken().String())
     }
 }
+
+func TestPositions(t *testing.T) {
+    lexer := NewLexer("let x = 5;\n  x == 10")
+    expected := []Position{
+        {0, 1, 1, 1}, {4, 1, 5, 5}, {6, 1, 7, 7}, {8, 1, 9, 9}, {9, 1, 10, 10},
+        {13, 2, 3, 3}, {15, 2, 5, 5}, {18, 2, 8, 8}, {20, 2, 10, 10},
+    }
+    for i, want := range expected {
+        token := lexer.NextToken()
+        if token.Pos != want {
+            t.Errorf("token %d %s: expected position %+v, got %+v", i, token, want, token.Pos)
+        }
+    }
+}
+
+func TestStrings(t *testing.T) {
+    tests := []struct {
+        input    string
+        expected Token
+    }{
+        {`"hello world"`, Token{Type: String, Literal: "hello world"}},
+        {`"a\nb\t\"c\"\\"`, Token{Type: String, Literal: "a\nb\t\"c\"\\"}},
+        {`"\u{1F600}\u{e9}"`, Token{Type: String, Literal: "\U0001F600\u00e9"}},
+        {`"unterminated`, Token{Type: Illegal, Literal: `"unterminated`}},
+        {`"bad \q escape"`, Token{Type: Illegal, Literal: `"bad \q escape`}},
+        {`"\u{110000}"`, Token{Type: Illegal, Literal: `"\u{110000}`}},
+    }
+    for _, tt := range tests {
+        token := NewLexer(tt.input).NextToken()
+        if token.Type != tt.expected.Type || token.Literal != tt.expected.Literal {
+            t.Errorf("%s: expected %s, got %s", tt.input, tt.expected.String(), token)
+        }
+    }
+}
+
+func TestComments(t *testing.T) {
+    input := "// header\n/* block\n comment */ let x = 1 / 2; // trailing\r\n/* a */ /* b */\nx /* inner */ + 1\n// end"
+    lexer := NewLexer(input)
+
+    var tokens []*Token
+    for {
+        token := lexer.NextToken()
+        tokens = append(tokens, token)
+        if token.Type == Eof {
+            break
+        }
+    }
+
+    var types []Type
+    for _, token := range tokens {
+        types = append(types, token.Type)
+    }
+    expectedTypes := "[LET IDENT = INT / INT ; IDENT + INT EOF]"
+    if got := fmt.Sprint(types); got != expectedTypes {
+        t.Fatalf("expected tokens %s, got %s", expectedTypes, got)
+    }
+
+    texts := func(comments []Comment) []string {
+        var t []string
+        for _, comment := range comments {
+            t = append(t, comment.Text)
+        }
+        return t
+    }
+    trivia := []struct {
+        token    int
+        leading  string
+        trailing string
+    }{
+        {0, "[// header /* block\n comment */]", "[]"},
+        {6, "[]", "[// trailing]"},
+        {7, "[/* a */ /* b */]", "[/* inner */]"},
+        {10, "[// end]", "[]"},
+    }
+    for _, tt := range trivia {
+        token := tokens[tt.token]
+        if got := fmt.Sprint(texts(token.Leading)); got != tt.leading {
+            t.Errorf("token %d %s: expected leading %q, got %q", tt.token, token, tt.leading, got)
+        }
+        if got := fmt.Sprint(texts(token.Trailing)); got != tt.trailing {
+            t.Errorf("token %d %s: expected trailing %q, got %q", tt.token, token, tt.trailing, got)
+        }
+    }
+
+    comments := lexer.Comments()
+    if len(comments) != 7 {
+        t.Fatalf("expected 7 comments, got %d", len(comments))
+    }
+    if comments[1].Pos != (Position{10, 2, 1, 1}) || comments[2].Pos != (Position{46, 3, 28, 28}) {
+        t.Errorf("unexpected comment positions %+v and %+v", comments[1].Pos, comments[2].Pos)
+    }
+    if !comments[2].IsLine() || comments[1].IsLine() {
+        t.Errorf("expected only %q to be a line comment", comments[2].Text)
+    }
+}
+
+func TestUnterminatedComment(t *testing.T) {
+    lexer := NewLexer("x /* open\n")
+    lexer.NextToken()
+    token := lexer.NextToken()
+    if token.Type != Illegal || token.Literal != "/* open\n" || token.Pos != (Position{2, 1, 3, 3}) {
+        t.Errorf("expected an illegal token for the unterminated comment, got %s at %+v", token, token.Pos)
+    }
+    if token := lexer.NextToken(); token.Type != Eof {
+        t.Errorf("expected EOF after the unterminated comment, got %s", token)
+    }
+}
+
+func TestOperators(t *testing.T) {
+    lexer := NewLexer("<= >= < > && || & | <=> % * ** *** ^ ~ << >> <<= >>> += -= *= /= **= ==")
+    expected := []Token{
+        {Type: Le, Literal: "<="}, {Type: Ge, Literal: ">="}, {Type: Lt, Literal: "<"}, {Type: Gt, Literal: ">"},
+        {Type: And, Literal: "&&"}, {Type: Or, Literal: "||"}, {Type: BitAnd, Literal: "&"}, {Type: BitOr, Literal: "|"},
+        {Type: Le, Literal: "<="}, {Type: Gt, Literal: ">"}, {Type: Percent, Literal: "%"}, {Type: Asterisk, Literal: "*"},
+        {Type: Power, Literal: "**"}, {Type: Power, Literal: "**"}, {Type: Asterisk, Literal: "*"},
+        {Type: BitXor, Literal: "^"}, {Type: BitNot, Literal: "~"}, {Type: Shl, Literal: "<<"}, {Type: Shr, Literal: ">>"},
+        {Type: Shl, Literal: "<<"}, {Type: Assign, Literal: "="}, {Type: Shr, Literal: ">>"}, {Type: Gt, Literal: ">"},
+        {Type: PlusEq, Literal: "+="}, {Type: MinusEq, Literal: "-="}, {Type: TimesEq, Literal: "*="},
+        {Type: DivideEq, Literal: "/="}, {Type: Power, Literal: "**"}, {Type: Assign, Literal: "="}, {Type: Eq, Literal: "=="},
+        {Type: Eof, Literal: ""},
+    }
+    for _, want := range expected {
+        if token := lexer.NextToken(); token.Type != want.Type || token.Literal != want.Literal {
+            t.Errorf("expected %s, got %s", want.String(), token)
+        }
+    }
+}
+
+func TestNumbers(t *testing.T) {
+    tests := []struct {
+        input    string
+        expected []Token
+    }{
+        {"42", []Token{{Type: Int, Literal: "42"}}},
+        {"1.5", []Token{{Type: Float, Literal: "1.5"}}},
+        {"1e-3", []Token{{Type: Float, Literal: "1e-3"}}},
+        {"2.5E+10", []Token{{Type: Float, Literal: "2.5E+10"}}},
+        {"1.", []Token{{Type: Int, Literal: "1"}, {Type: Illegal, Literal: "."}}},
+        {"a[1].5", []Token{{Type: Ident, Literal: "a"}, {Type: Lbracket, Literal: "["}, {Type: Int, Literal: "1"},
+            {Type: Rbracket, Literal: "]"}, {Type: Illegal, Literal: "."}, {Type: Int, Literal: "5"}}},
+        {"1e", []Token{{Type: Illegal, Literal: "1e"}}},
+        {"3e+x", []Token{{Type: Illegal, Literal: "3e+x"}}},
+        {"0xFF", []Token{{Type: Int, Literal: "0xFF"}}},
+        {"0XdeadBEEF", []Token{{Type: Int, Literal: "0XdeadBEEF"}}},
+        {"0o755", []Token{{Type: Int, Literal: "0o755"}}},
+        {"0b1010", []Token{{Type: Int, Literal: "0b1010"}}},
+        {"1_000_000", []Token{{Type: Int, Literal: "1_000_000"}}},
+        {"0x_FF_FF", []Token{{Type: Int, Literal: "0x_FF_FF"}}},
+        {"1_000.000_5e1_0", []Token{{Type: Float, Literal: "1_000.000_5e1_0"}}},
+        {"0b102", []Token{{Type: Illegal, Literal: "0b102"}}},
+        {"0o8", []Token{{Type: Illegal, Literal: "0o8"}}},
+        {"0x", []Token{{Type: Illegal, Literal: "0x"}}},
+        {"0xG1", []Token{{Type: Illegal, Literal: "0xG1"}}},
+        {"0", []Token{{Type: Int, Literal: "0"}}},
+        {"0755", []Token{{Type: Illegal, Literal: "0755"}}},
+        {"08", []Token{{Type: Illegal, Literal: "08"}}},
+        {"0_1", []Token{{Type: Illegal, Literal: "0_1"}}},
+        {"0.5", []Token{{Type: Float, Literal: "0.5"}}},
+        {"012.5", []Token{{Type: Float, Literal: "012.5"}}},
+        {"1__0", []Token{{Type: Illegal, Literal: "1__0"}}},
+        {"1_", []Token{{Type: Illegal, Literal: "1_"}}},
+        {"1_.5", []Token{{Type: Illegal, Literal: "1_.5"}}},
+        {"1_e5", []Token{{Type: Illegal, Literal: "1_e5"}}},
+        {"12ab + 1", []Token{{Type: Illegal, Literal: "12ab"}, {Type: Plus, Literal: "+"}, {Type: Int, Literal: "1"}}},
+        {"1.5.2", []Token{{Type: Float, Literal: "1.5"}, {Type: Illegal, Literal: "."}, {Type: Int, Literal: "2"}}},
+    }
+    for _, tt := range tests {
+        lexer := NewLexer(tt.input)
+        for _, want := range tt.expected {
+            token := lexer.NextToken()
+            if token.Type != want.Type || token.Literal != want.Literal {
+                t.Errorf("%s: expected %s, got %s", tt.input, want.String(), token)
+            }
+        }
+        if token := lexer.NextToken(); token.Type != Eof {
+            t.Errorf("%s: expected EOF, got %s", tt.input, token)
+        }
+    }
+}
+
+func TestUnicode(t *testing.T) {
+    lexer := NewLexer("let 名字 = \"héllo\";\n_private + x1 + café2 \xff + ١")
+    expected := []struct {
+        token Token
+        pos   Position
+    }{
+        {Token{Type: Let, Literal: "let"}, Position{0, 1, 1, 1}},
+        {Token{Type: Ident, Literal: "名字"}, Position{4, 1, 5, 5}},
+        {Token{Type: Assign, Literal: "="}, Position{11, 1, 8, 12}},
+        {Token{Type: String, Literal: "héllo"}, Position{13, 1, 10, 14}},
+        {Token{Type: Semicolon, Literal: ";"}, Position{21, 1, 17, 22}},
+        {Token{Type: Ident, Literal: "_private"}, Position{23, 2, 1, 1}},
+        {Token{Type: Plus, Literal: "+"}, Position{32, 2, 10, 10}},
+        {Token{Type: Ident, Literal: "x1"}, Position{34, 2, 12, 12}},
+        {Token{Type: Plus, Literal: "+"}, Position{37, 2, 15, 15}},
+        {Token{Type: Ident, Literal: "café2"}, Position{39, 2, 17, 17}},
+        {Token{Type: Illegal, Literal: "\xff"}, Position{46, 2, 23, 24}},
+        {Token{Type: Plus, Literal: "+"}, Position{48, 2, 25, 26}},
+        {Token{Type: Illegal, Literal: "١"}, Position{50, 2, 27, 28}},
+        {Token{Type: Eof, Literal: ""}, Position{52, 2, 28, 30}},
+    }
+    for i, want := range expected {
+        token := lexer.NextToken()
+        if token.Type != want.token.Type || token.Literal != want.token.Literal || token.Pos != want.pos {
+            t.Errorf("token %d: expected %s at %+v, got %s at %+v", i, want.token.String(), want.pos, token, token.Pos)
+        }
+    }
+}
+
+func TestReaderLexer(t *testing.T) {
+    var builder strings.Builder
+    builder.WriteString("/* header */\nlet 名字 = \"" + strings.Repeat("é", 3000) + "\";\n")
+    for i := 0; i < 500; i++ {
+        builder.WriteString("let x_1 = [0x_FF, 1.5e3, \"\\u{1F600}\"]; // line\n")
+    }
+    builder.WriteString("/* unterminated")
+    input := builder.String()
+
+    expected := NewLexer(input)
+    lexer := NewReaderLexer(iotest.OneByteReader(strings.NewReader(input)))
+    for {
+        want, got := expected.NextToken(), lexer.NextToken()
+        if got.Type != want.Type || got.Literal != want.Literal || got.Pos != want.Pos ||
+            fmt.Sprint(got.Leading, got.Trailing) != fmt.Sprint(want.Leading, want.Trailing) {
+            t.Fatalf("expected %s at %+v, got %s at %+v", want, want.Pos, got, got.Pos)
+        }
+        if want.Type == Eof {
+            break
+        }
+    }
+    if len(lexer.Comments()) != 501 || lexer.Err() != nil {
+        t.Errorf("expected 501 comments and no error, got %d and %v", len(lexer.Comments()), lexer.Err())
+    }
+    if len(lexer.buf) > 2*readSize+len(input)/100 {
+        t.Errorf("expected the buffer to hold about one token, it holds %d bytes", len(lexer.buf))
+    }
+}
+
+func TestReaderLexerError(t *testing.T) {
+    failure := errors.New("disk on fire")
+    lexer := NewReaderLexer(io.MultiReader(strings.NewReader("let x = 12"), iotest.ErrReader(failure)))
+    var types []Type
+    for token := lexer.NextToken(); token.Type != Eof; token = lexer.NextToken() {
+        types = append(types, token.Type)
+    }
+    if fmt.Sprint(types) != "[LET IDENT = INT]" || lexer.Err() != failure {
+        t.Errorf("expected the tokens before the error and the error, got %v and %v", types, lexer.Err())
+    }
+}
@@ -1,26 +1,52 @@
 package token
 
+import (
+	"fmt"
+	"unicode"
+)
+
 const (
 	Illegal   = "ILLEGAL"
 	Eof       = "EOF"
 	Ident     = "IDENT"
 	Int       = "INT"
+	Float     = "FLOAT"
+	String    = "STRING"
 	Assign    = "="
+	PlusEq    = "+="
+	MinusEq   = "-="
+	TimesEq   = "*="
+	DivideEq  = "/="
 	Plus      = "+"
 	Minus     = "-"
 	Bang      = "!"
 	Asterisk  = "*"
 	Slash     = "/"
+	Percent   = "%"
+	Power     = "**"
+	BitAnd    = "&"
+	BitOr     = "|"
+	BitXor    = "^"
+	BitNot    = "~"
+	Shl       = "<<"
+	Shr       = ">>"
 	Lt        = "<"
 	Gt        = ">"
+	Le        = "<="
+	Ge        = ">="
+	And       = "&&"
+	Or        = "||"
 	Eq        = "=="
 	Ne        = "!="
 	Comma     = ","
+	Colon     = ":"
 	Semicolon = ";"
 	Lparen    = "("
 	Rparen    = ")"
 	Lbrace    = "{"
 	Rbrace    = "}"
+	Lbracket  = "["
+	Rbracket  = "]"
 	Function  = "FUNCTION"
 	Let       = "LET"
 	If        = "IF"
@@ -28,6 +54,7 @@ const (
 	True      = "TRUE"
 	False     = "FALSE"
 	Return    = "RETURN"
+	Macro     = "MACRO"
 )
 
 var KEYWORDS = map[string]Type{
@@ -38,19 +65,49 @@ var KEYWORDS = map[string]Type{
 	"true":   True,
 	"false":  False,
 	"return": Return,
+	"macro":  Macro,
 }
 
 type Type = string
 
 type Token struct {
-	Type    Type
-	Literal string
+	Type     Type
+	Literal  string
+	Pos      Position
+	Leading  []Comment // comments on the lines before the token
+	Trailing []Comment // comments after the token that start on its line
 }
 
 func (token *Token) String() string {
 	return "<" + token.Type + ", " + token.Literal + ">"
 }
 
+// Position locates a token in the source. Offset is the 0-based byte offset, Line and Column are
+// 1-based and Column counts runes, so that a column matches what an editor shows for UTF-8 text.
+// ByteColumn is the 1-based column in bytes, as tools working on the raw source expect it.
+type Position struct {
+	Offset     int
+	Line       int
+	Column     int
+	ByteColumn int
+}
+
+func (pos Position) String() string {
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+}
+
+// Comment is a // line comment or a /* */ block comment. Text includes the delimiters
+// but not the newline ending a line comment.
+type Comment struct {
+	Text string
+	Pos  Position
+}
+
+// IsLine reports whether the comment is a // line comment, which must be followed by a newline.
+func (comment Comment) IsLine() bool {
+	return len(comment.Text) >= 2 && comment.Text[1] == '/'
+}
+
 func newToken(tokenType Type, literal string) *Token {
 	return &Token{
 		Type:    tokenType,
@@ -63,10 +120,43 @@ func isKeyword(word string) (ok bool, tokenType Type) {
 	return
 }
 
-func isLetter(char byte) bool {
-	return ('a' <= char && char <= 'z') || ('A' <= char && char <= 'Z')
+// isLetter reports whether char may start an identifier.
+func isLetter(char rune) bool {
+	return char == '_' || unicode.IsLetter(char)
 }
 
-func isDigit(value byte) bool {
+// isDigit only accepts ASCII digits, which are the digits of number literals.
+func isDigit(value rune) bool {
 	return '0' <= value && value <= '9'
 }
+
+func isHexDigit(value rune) bool {
+	return isDigit(value) || ('a' <= value && value <= 'f') || ('A' <= value && value <= 'F')
+}
+
+func isDigitOf(value rune, base int) bool {
+	switch base {
+	case 2:
+		return value == '0' || value == '1'
+	case 8:
+		return '0' <= value && value <= '7'
+	case 16:
+		return isHexDigit(value)
+	default:
+		return isDigit(value)
+	}
+}
+
+// basePrefix returns the base selected by the character after a leading 0, or 0 if there is none.
+func basePrefix(char rune) int {
+	switch char {
+	case 'x', 'X':
+		return 16
+	case 'o', 'O':
+		return 8
+	case 'b', 'B':
+		return 2
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,496 @@
+package vm
+
+import (
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/eval"
+	"strconv"
+)
+
+const (
+	StackSize   = 2048 // the initial number of stack slots, the stack grows as calls need more
+	GlobalsSize = 65536
+	MaxFrames   = eval.MaxCallDepth + 1 // the main program and the function calls
+)
+
+// Frame is the activation of a closure: ip points at the current instruction and
+// basePointer at the first local slot on the stack.
+type Frame struct {
+	closure     *eval.ClosureObject
+	ip          int
+	basePointer int
+}
+
+func NewFrame(closure *eval.ClosureObject, basePointer int) *Frame {
+	return &Frame{closure: closure, ip: -1, basePointer: basePointer}
+}
+
+func (frame *Frame) Instructions() code.Instructions {
+	return frame.closure.Fn.Instructions
+}
+
+type VM struct {
+	constants []eval.Object
+
+	stack []eval.Object
+	sp    int // always points to the next free slot, the top of stack is stack[sp-1]
+
+	globals     []eval.Object
+	globalNames []string
+
+	frames      []*Frame
+	framesIndex int
+
+	result eval.Object
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &eval.CompiledFunctionObject{Instructions: bytecode.Instructions, Lines: bytecode.Lines}
+	mainFrame := NewFrame(&eval.ClosureObject{Fn: mainFn}, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
+	return &VM{
+		constants:   bytecode.Constants,
+		stack:       make([]eval.Object, StackSize),
+		sp:          0,
+		globals:     make([]eval.Object, GlobalsSize),
+		globalNames: bytecode.Globals,
+		frames:      frames,
+		framesIndex: 1,
+	}
+}
+
+// localName returns the name of local slot index of function for error messages.
+func localName(function *eval.CompiledFunctionObject, index int) string {
+	if index < len(function.LocalNames) {
+		return function.LocalNames[index]
+	}
+	return "local " + strconv.Itoa(index)
+}
+
+// globalName returns the name of global slot index for error messages.
+func (vm *VM) globalName(index uint16) string {
+	if int(index) < len(vm.globalNames) {
+		return vm.globalNames[index]
+	}
+	return "global " + strconv.Itoa(int(index))
+}
+
+// NewWithGlobalsStore creates a VM that shares its globals with earlier runs, e.g. between the entries of a REPL.
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, globals []eval.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = globals
+	return vm
+}
+
+func NewGlobalsStore() []eval.Object {
+	return make([]eval.Object, GlobalsSize)
+}
+
+// Result returns the value of the program like eval.Eval does: the value of the last top-level
+// expression statement or return statement, or nil if the program ended with a let statement.
+func (vm *VM) Result() eval.Object {
+	return vm.result
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(frame *Frame) error {
+	if vm.framesIndex == MaxFrames {
+		return eval.NewError("stack overflow")
+	}
+	vm.frames[vm.framesIndex] = frame
+	vm.framesIndex++
+	return nil
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+func (vm *VM) inMainFrame() bool {
+	return vm.framesIndex == 1
+}
+
+// Run executes the program. Runtime errors are returned as *eval.ErrorObject.
+func (vm *VM) Run() error {
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		frame := vm.currentFrame()
+		ip := frame.ip
+		ins := frame.Instructions()
+		op := code.Opcode(ins[ip])
+
+		var err error
+		switch op {
+		case code.OpConstant:
+			index := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			err = vm.push(vm.constants[index])
+		case code.OpPop:
+			value := vm.pop()
+			if vm.inMainFrame() {
+				vm.result = value
+			}
+		case code.OpTrue:
+			err = vm.push(eval.TRUE)
+		case code.OpFalse:
+			err = vm.push(eval.FALSE)
+		case code.OpNull:
+			err = vm.push(eval.NULL)
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan,
+			code.OpGreaterEqual, code.OpLessEqual, code.OpMod, code.OpPow,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight:
+			err = vm.executeBinaryOperation(op)
+		case code.OpMinus:
+			err = vm.pushResult(eval.PrefixOperation("-", vm.pop()))
+		case code.OpBitNot:
+			err = vm.pushResult(eval.PrefixOperation("~", vm.pop()))
+		case code.OpBang:
+			err = vm.push(nativeBool(!eval.IsTruthy(vm.pop())))
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip = pos - 1
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			if !eval.IsTruthy(vm.pop()) {
+				frame.ip = pos - 1
+			}
+		case code.OpSetGlobal:
+			index := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			vm.globals[index] = vm.pop()
+			if vm.inMainFrame() {
+				vm.result = nil
+			}
+		case code.OpGetGlobal:
+			index := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			if vm.globals[index] == nil {
+				err = eval.NewError("identifier not found: %s", vm.globalName(index))
+			} else {
+				err = vm.push(vm.globals[index])
+			}
+		case code.OpCheckGlobal:
+			index := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			if vm.globals[index] == nil {
+				err = eval.NewError("cannot assign to undeclared identifier: %s", vm.globalName(index))
+			}
+		case code.OpSetLocal:
+			index := code.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+			vm.stack[frame.basePointer+int(index)] = vm.pop()
+		case code.OpGetLocal:
+			index := code.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+			if value := vm.stack[frame.basePointer+int(index)]; value == nil {
+				err = eval.NewError("identifier not found: %s", localName(frame.closure.Fn, int(index)))
+			} else {
+				err = vm.push(value)
+			}
+		case code.OpGetBuiltin:
+			index := code.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+			err = vm.push(eval.Builtins()[index])
+		case code.OpGetFree:
+			index := code.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+			err = vm.push(frame.closure.Free[index])
+		case code.OpCurrentClosure:
+			err = vm.push(frame.closure)
+		case code.OpArray:
+			count := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			elements := make([]eval.Object, count)
+			copy(elements, vm.stack[vm.sp-count:vm.sp])
+			vm.sp -= count
+			err = vm.push(&eval.ArrayObject{Elements: elements})
+		case code.OpHash:
+			count := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			err = vm.executeHash(count)
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(eval.IndexOperation(left, index))
+		case code.OpPeekIndex:
+			err = vm.pushResult(eval.IndexOperation(vm.stack[vm.sp-2], vm.stack[vm.sp-1]))
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(eval.SetIndexOperation(left, index, value))
+		case code.OpLocalCell:
+			index := code.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+			err = vm.push(vm.localCell(frame.basePointer+int(index), localName(frame.closure.Fn, int(index))))
+		case code.OpGetCell:
+			if c := vm.pop().(*cell); c.value == nil {
+				err = eval.NewError("identifier not found: %s", c.name)
+			} else {
+				err = vm.push(c.value)
+			}
+		case code.OpSetCell:
+			c := vm.pop().(*cell)
+			c.value = vm.pop()
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+			err = vm.executeCall(int(numArgs))
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+			if vm.inMainFrame() {
+				vm.result = returnValue
+				return nil
+			}
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+			err = vm.push(returnValue)
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+			err = vm.push(eval.NULL)
+		case code.OpClosure:
+			index := code.ReadUint16(ins[ip+1:])
+			numFree := int(code.ReadUint8(ins[ip+3:]))
+			frame.ip += 3
+			err = vm.pushClosure(int(index), numFree)
+		default:
+			def, lookupErr := code.Lookup(byte(op))
+			if lookupErr != nil {
+				return lookupErr
+			}
+			return eval.NewError("unhandled opcode %s", def.Name)
+		}
+
+		if err != nil {
+			return vm.annotate(err)
+		}
+	}
+	return nil
+}
+
+// annotate sets the source position of a runtime error and records the active calls in its trace.
+func (vm *VM) annotate(err error) error {
+	errObject, ok := err.(*eval.ErrorObject)
+	if !ok {
+		return err
+	}
+
+	if errObject.Pos.Line == 0 {
+		frame := vm.currentFrame()
+		errObject.Pos = frame.closure.Fn.Lines.Lookup(frame.ip)
+	}
+	for i := vm.framesIndex - 1; i > 0; i-- {
+		caller := vm.frames[i-1]
+		errObject.Trace = append(errObject.Trace, eval.Frame{
+			Function: eval.FunctionName(vm.frames[i].closure.Fn.Name),
+			Pos:      caller.closure.Fn.Lines.Lookup(caller.ip),
+		})
+	}
+	return errObject
+}
+
+func (vm *VM) executeBinaryOperation(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	// integers are by far the most common operands, so they skip the generic path
+	leftInt, leftOk := left.(*eval.IntegerObject)
+	rightInt, rightOk := right.(*eval.IntegerObject)
+	if leftOk && rightOk {
+		switch op {
+		case code.OpAdd:
+			return vm.push(&eval.IntegerObject{Value: leftInt.Value + rightInt.Value})
+		case code.OpSub:
+			return vm.push(&eval.IntegerObject{Value: leftInt.Value - rightInt.Value})
+		case code.OpMul:
+			return vm.push(&eval.IntegerObject{Value: leftInt.Value * rightInt.Value})
+		case code.OpEqual:
+			return vm.push(nativeBool(leftInt.Value == rightInt.Value))
+		case code.OpNotEqual:
+			return vm.push(nativeBool(leftInt.Value != rightInt.Value))
+		case code.OpGreaterThan:
+			return vm.push(nativeBool(leftInt.Value > rightInt.Value))
+		case code.OpLessThan:
+			return vm.push(nativeBool(leftInt.Value < rightInt.Value))
+		case code.OpGreaterEqual:
+			return vm.push(nativeBool(leftInt.Value >= rightInt.Value))
+		case code.OpLessEqual:
+			return vm.push(nativeBool(leftInt.Value <= rightInt.Value))
+		case code.OpBitAnd:
+			return vm.push(&eval.IntegerObject{Value: leftInt.Value & rightInt.Value})
+		case code.OpBitOr:
+			return vm.push(&eval.IntegerObject{Value: leftInt.Value | rightInt.Value})
+		case code.OpBitXor:
+			return vm.push(&eval.IntegerObject{Value: leftInt.Value ^ rightInt.Value})
+		}
+	}
+	return vm.pushResult(eval.InfixOperation(binaryOperators[op], left, right))
+}
+
+var binaryOperators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpGreaterThan:  ">",
+	code.OpLessThan:     "<",
+	code.OpGreaterEqual: ">=",
+	code.OpLessEqual:    "<=",
+	code.OpMod:          "%",
+	code.OpPow:          "**",
+	code.OpBitAnd:       "&",
+	code.OpBitOr:        "|",
+	code.OpBitXor:       "^",
+	code.OpShiftLeft:    "<<",
+	code.OpShiftRight:   ">>",
+}
+
+func (vm *VM) executeHash(count int) error {
+	hash := eval.NewHashObject()
+	for i := vm.sp - count; i < vm.sp; i += 2 {
+		key, ok := vm.stack[i].(eval.Hashable)
+		if !ok {
+			return eval.NewError("unusable as hash key: %s", vm.stack[i].Type())
+		}
+		hash.Set(key, vm.stack[i+1])
+	}
+	vm.sp -= count
+	return vm.push(hash)
+}
+
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *eval.ClosureObject:
+		return vm.callClosure(callee, numArgs)
+	case *eval.BuiltinObject:
+		return vm.callBuiltin(callee, numArgs)
+	case nil:
+		return eval.NewError("not a function: nothing")
+	default:
+		return eval.NewError("not a function: %s", callee.Type())
+	}
+}
+
+func (vm *VM) callClosure(closure *eval.ClosureObject, numArgs int) error {
+	if numArgs != closure.Fn.NumParameters {
+		return eval.WrongNumberOfArguments(closure.Fn.NumParameters, numArgs)
+	}
+
+	frame := NewFrame(closure, vm.sp-numArgs)
+	if err := vm.pushFrame(frame); err != nil {
+		return err
+	}
+	vm.sp = frame.basePointer + closure.Fn.NumLocals
+	vm.growStack(vm.sp)
+	// clear the locals left over from earlier calls, so that OpLocalCell never finds a stale cell
+	for i := frame.basePointer + numArgs; i < vm.sp; i++ {
+		vm.stack[i] = nil
+	}
+	return nil
+}
+
+// cell holds a local that closures capture by reference, because it is assigned after it is captured.
+// name is the name of the local, for the error about reading it while it is unbound.
+type cell struct {
+	value eval.Object
+	name  string
+}
+
+func (c *cell) Type() eval.ObjectType {
+	return "CELL"
+}
+
+func (c *cell) Inspect() string {
+	return "cell"
+}
+
+// localCell returns the cell in stack slot i, moving the value of the slot into a new cell for the local
+// name first if needed.
+func (vm *VM) localCell(i int, name string) *cell {
+	c, ok := vm.stack[i].(*cell)
+	if !ok {
+		c = &cell{value: vm.stack[i], name: name}
+		vm.stack[i] = c
+	}
+	return c
+}
+
+func (vm *VM) callBuiltin(builtin *eval.BuiltinObject, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+	result := builtin.Fn(args...)
+	vm.sp = vm.sp - numArgs - 1
+
+	if result == nil {
+		return vm.push(eval.NULL)
+	}
+	return vm.pushResult(result)
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	function, ok := vm.constants[constIndex].(*eval.CompiledFunctionObject)
+	if !ok {
+		return eval.NewError("not a function: %s", vm.constants[constIndex].Type())
+	}
+
+	free := make([]eval.Object, numFree)
+	copy(free, vm.stack[vm.sp-numFree:vm.sp])
+	vm.sp -= numFree
+	return vm.push(&eval.ClosureObject{Fn: function, Free: free})
+}
+
+// pushResult pushes the result of a shared eval operation, turning an error object into a Go error.
+func (vm *VM) pushResult(result eval.Object) error {
+	if err, ok := result.(*eval.ErrorObject); ok {
+		return err
+	}
+	return vm.push(result)
+}
+
+func (vm *VM) push(object eval.Object) error {
+	vm.growStack(vm.sp + 1)
+	vm.stack[vm.sp] = object
+	vm.sp++
+	return nil
+}
+
+// growStack makes room for size stack slots. Only MaxFrames limits the depth of calls, like MaxCallDepth in
+// the evaluator, however many slots the frames take.
+func (vm *VM) growStack(size int) {
+	if size <= len(vm.stack) {
+		return
+	}
+	newSize := 2 * len(vm.stack)
+	if newSize < size {
+		newSize = size
+	}
+	stack := make([]eval.Object, newSize)
+	copy(stack, vm.stack)
+	vm.stack = stack
+}
+
+func (vm *VM) pop() eval.Object {
+	object := vm.stack[vm.sp-1]
+	vm.sp--
+	return object
+}
+
+func nativeBool(value bool) *eval.BooleanObject {
+	if value {
+		return eval.TRUE
+	}
+	return eval.FALSE
+}
@@ -0,0 +1,285 @@
+package vm
+
+import (
+	"bytes"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/eval"
+	"monkey/token"
+	"os"
+	"testing"
+)
+
+// conformanceTests are run by both engines, which must agree on the result, the error message and the output.
+var conformanceTests = []string{
+	// literals and operators
+	"5",
+	"-5 + 10 * 2 - 3 / 2",
+	"(5 + 10) / 3",
+	"1 < 2 == true",
+	"1 > 2 != false",
+	"!true",
+	"!!5",
+	"!(if (false) { 1 })",
+	`"Hello" + ", " + "World!"`,
+	`"a" == "a"`,
+	`"a" != "b"`,
+	"true == true",
+	"[1] == [1]",
+	"0xFF + 0o17 + 0b11 + 1_000",
+	"1.5 * 2 - 0.25",
+	"-2.5 / 0.5",
+	"7 / 2 + 7 / 2.0",
+	"1 == 1.0",
+	"[7 % 3, -7 % 3, 7.5 % 2, 2 ** 10, 2 ** 3 ** 2, -2 ** 2, 2.0 ** -1, 4 ** 0.5]",
+	"[12 & 10, 12 | 10, 12 ^ 10, ~5, ~-1, 1 << 10, -16 >> 2, 1 << 64]",
+	"let hash = fn(s, h) { if (len(s) == 0) { h } else { hash(rest(s), (h * 31 + first(s)) % 1000000007) } }; hash([1, 2, 3], 7)",
+	"let flags = 1 | 4; [flags & 4 != 0, flags & 2 != 0, flags & ~1]",
+	"let x = 1; x = 2; x += 3; x -= 1; x *= 10; x /= 4; [x, x = 7, x]",
+	"let x = 1; let y = 2; x = y = 5; [x, y]",
+	"let x = 1; let f = fn() { x *= 2 }; f(); f(); x",
+	"let x = 1; let f = fn(x) { x = 2; x }; [f(0), x]",
+	"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); let d = counter(); c(); c(); [c(), d()]",
+	"let acc = fn(total) { fn(n) { total += n; total } }; let a = acc(10); a(1); a(2)",
+	"let f = fn() { let v = 1; let get = fn() { v }; v = 5; get() }; f()",
+	"let f = fn() { let v = 1; let g = fn() { fn() { v += 1 } }; g()(); g()(); v }; f()",
+	"let f = fn() { let a = 0; let b = fn() { a = 1 }; if (false) { let a = 2 }; b(); a }; f()",
+	"let f = fn() { let x = 1; let g = fn() { x }; let x = 2; g() }; f()",
+	"let f = fn(x) { if (x > 0) { let y = x; } y }; puts(f(0))",
+	"let f = fn(x) { if (x > 0) { let y = x; } y + 1 }; [f(1), f(0)]",
+	"let f = fn(x) { if (x > 0) { let y = x; } [y] }; f(0)",
+	"let f = fn(x) { if (x > 0) { let y = x; } let g = fn() { y }; if (x > 1) { g() } else { 0 } }; [f(0), f(2)]",
+	"let f = fn(x) { if (x > 0) { let y = x; } let g = fn() { fn() { y } }; g() }; let h = f(0); [f(1)(), h()]",
+	"let f = fn(x) { let g = fn() { x }; let x = x * 10; g() }; f(3)",
+	"let f = fn() { let r = fn(n) { if (n == 0) { 0 } else { r(n - 1) + 1 } }; let v = r(3); r = 10; [v, r] }; f()",
+	"let g = fn() { g = 2; 1 }; [g(), g]",
+	"let a = [1, 2, 3]; let b = a; b[0] = 9; a[2] *= 5; a[1] += a[0]; [a, b]",
+	`let h = {"k": 1}; h["k"] += 2; h["n"] = h["k"] * 2; h`,
+	`let a = [0, 0]; let i = fn() { puts("i"); 1 }; a[i()] += 5; a`,
+	"let a = [[1], [2]]; a[1][0] = 3; a",
+	"let a = fn() { b() }; let b = fn() { 1 }; a()",
+	"let down = fn(n) { if (n == 0) { 0 } else { 1 + down(n - 1) } }; down(500)",
+	"let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; [even(10), odd(7)]",
+	"if (false) { undefined }; false && undefined; 1",
+	"let f = fn() { if (false) { x = 1 }; 2 }; f()",
+	"let f = fn() { later = 2 }; let later = 1; f(); later",
+	"[1 <= 2, 2 <= 1, 3 >= 3, 2 >= 3, 1.5 <= 2, 2 >= 2.5]",
+	"[true && true, true && false, false && true, false || false, false || 1, 0 || false]",
+	"let calls = fn(x) { puts(x); x }; calls(false) && calls(true); calls(true) || calls(false)",
+	"1 > 0 && 2 > 1 || 3 < 0",
+	"0.5 < 1",
+	"let ratio = fn(a, b) { a / (b * 1.0) }; ratio(1, 3)",
+
+	// conditionals
+	"if (1 > 2) { 10 }",
+	"if (1 < 2) { 10 } else { 20 }",
+	"if (false) { 10 } else { }",
+	"if (true) { let a = 1; }",
+	"if (10 > 1) { if (10 > 1) { return 10; } return 1; }",
+	"1; return 2; 3",
+
+	// bindings
+	"let a = 5",
+	"let a = 5; let b = a * 2; b",
+	"1; let a = 2;",
+	"let a = 1; let a = a + 1; a",
+	"let 数量 = 3; let unit_price = 1.5; 数量 * unit_price",
+
+	// collections
+	"[1, 2 * 2, 3 + 3]",
+	"[]",
+	"let xs = [1, 2, 3]; xs[1] + xs[2]",
+	"[[1, 2], [3]][0][1] * 10",
+	`{"one": 1, "two": 1 + 1, 3: "three", true: [4]}`,
+	`let h = {"a": 1, "b": 2}; h["b"]`,
+	`{"a": 5}["b"]`,
+	"{1: 1, 1: 2}",
+
+	// functions and closures
+	"let add = fn(x, y) { x + y; }; add(2, add(3, 4))",
+	"fn(x) { return x * 2; 0 }(4)",
+	"fn() { }()",
+	"fn() { let a = 1; }()",
+	"let adder = fn(x) { fn(y) { x + y } }; let addTwo = adder(2); addTwo(3)",
+	"let x = 10; let f = fn() { x }; let g = fn(x) { f() }; g(1)",
+	"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)",
+	"let x = 1; let f = fn(x) { let x = x + 1; x }; f(5) + x",
+	"let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3)",
+	"let counter = fn(n) { let loop = fn(i) { if (i == 0) { 0 } else { 1 + loop(i - 1) } }; loop(n) }; counter(50)",
+	"fn() { [fn(x) { x + 1 }] }()[0](41)",
+
+	// builtins
+	`len("héllo") + len([1, 2]) + len({"a": 1})`,
+	"first([1, 2, 3]) + last([1, 2, 3])",
+	"rest([1, 2, 3])",
+	"first([])",
+	"let a = [1]; let b = push(a, 2); [a, b]",
+	`puts("a", 1 + 1)`,
+	"let len = fn(x) { 42 }; len([])",
+
+	// errors
+	"5 + true",
+	"5 + true; 5",
+	"-true",
+	"true + false",
+	"5 / 0",
+	"5 / 0.0",
+	"5 % 0",
+	"5.5 % 0.0",
+	"2 ** -3",
+	"1 << -1",
+	"8 >> -1",
+	"1.5 | 2",
+	"~[1]",
+	"true && 1 + true",
+	"let f = fn() { false || f }; f() && -true",
+	`"a" >= "b"`,
+	"let x = true; x += 1",
+	"let f = fn() { g() }; f()",
+	"let f = fn() { f() }; f()",
+	"let f = fn() { 1 + f() }; let g = fn() { f() }; g()",
+	"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(1000)",
+	"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(1022)",
+	"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(1023)",
+	"let f = fn(n) { let a = 1; let b = 2; if (n == 0) { [a, b, [a, b, [a, b]]] } else { [a, b, 1 + len(f(n - 1))] } }; f(1022)",
+	"let f = fn() { g }; f(); let g = 1",
+	"let f = fn() { y = 1 }; f(); let y = 2",
+	"let f = fn() { z += 1 }; f()",
+	"let h = fn() { w }; if (false) { let w = 1 }; h()",
+	"let f = fn() { let a = [1]; a[1] = 2 }; f()",
+	"let a = [1]; a[0] += true",
+	`let s = "ab"; s[0] = "c"`,
+	"let h = {}; h[[1]] = 2",
+	`let h = {}; h["k"] -= 1`,
+	`let f = fn(x) { x = x / 0 }; f(1)`,
+	"2.5 + false",
+	"{0.5: 1}",
+	"1(2)",
+	`"a" - "b"`,
+	"[1, 2, 3][3]",
+	"1[0]",
+	`{"a": 1}[fn(x) { x }]`,
+	"{[1]: 1}",
+	"fn(x) { x }(1, 2)",
+	"len(1)",
+	"let f = fn() { 1 + true }; let g = fn() { f() }; g()",
+	"let f = fn(x) {\n  x()\n};\nlet g = fn() { f(1) };\ng()",
+	"let f = fn(g) { g(1) }; f(fn(x) { x + [x] })",
+	"let f = fn(x) { x }; fn() { f(1, 2) }()",
+	"let h = fn() { len(1) }; h()",
+}
+
+// describeError renders everything the engines must agree on for an error.
+func describeError(err *eval.ErrorObject) string {
+	return err.Pos.String() + ": " + err.Message + "\n" + err.StackTrace()
+}
+
+func runEval(program *ast.Program) (eval.Object, string) {
+	result := eval.Eval(program, eval.NewEnvironment())
+	if err, ok := result.(*eval.ErrorObject); ok {
+		return nil, describeError(err)
+	}
+	return result, ""
+}
+
+func runVM(t *testing.T, input string, program *ast.Program) (eval.Object, string) {
+	comp := compiler.NewCompiler()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("%q: compile error %s", input, err)
+	}
+	machine := New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		return nil, describeError(err.(*eval.ErrorObject))
+	}
+	return machine.Result(), ""
+}
+
+func inspect(object eval.Object) string {
+	if object == nil {
+		return "<nil>"
+	}
+	return object.Inspect()
+}
+
+func TestConformance(t *testing.T) {
+	var out bytes.Buffer
+	eval.Output = &out
+	defer func() { eval.Output = os.Stdout }()
+
+	for _, input := range conformanceTests {
+		program, errs := ast.NewParser(token.NewLexer(input)).Parse()
+		if len(errs) != 0 {
+			t.Fatalf("%q: parse errors %v", input, errs)
+		}
+
+		out.Reset()
+		evalResult, evalErr := runEval(program)
+		evalOutput := out.String()
+
+		out.Reset()
+		vmResult, vmErr := runVM(t, input, program)
+		vmOutput := out.String()
+
+		if evalErr != vmErr {
+			t.Errorf("%q: eval error %q, vm error %q", input, evalErr, vmErr)
+		}
+		if inspect(evalResult) != inspect(vmResult) {
+			t.Errorf("%q: eval result %s, vm result %s", input, inspect(evalResult), inspect(vmResult))
+		}
+		if evalOutput != vmOutput {
+			t.Errorf("%q: eval output %q, vm output %q", input, evalOutput, vmOutput)
+		}
+	}
+}
+
+func TestGlobalsStore(t *testing.T) {
+	globals := NewGlobalsStore()
+	symbolTable := compiler.NewCompiler().SymbolTable()
+	var constants []eval.Object
+
+	var result eval.Object
+	for _, input := range []string{"let a = 1;", "let b = fn() { a + 1 };", "b() * 10"} {
+		program, _ := ast.NewParser(token.NewLexer(input)).Parse()
+		comp := compiler.NewCompilerWithState(symbolTable, constants)
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("%q: compile error %s", input, err)
+		}
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+
+		machine := NewWithGlobalsStore(bytecode, globals)
+		if err := machine.Run(); err != nil {
+			t.Fatalf("%q: runtime error %s", input, err)
+		}
+		result = machine.Result()
+	}
+
+	if inspect(result) != "20" {
+		t.Errorf("expected 20, got %s", inspect(result))
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	program, _ := ast.NewParser(token.NewLexer("let f = fn() { f() }; f()")).Parse()
+	comp := compiler.NewCompiler()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compile error %s", err)
+	}
+	err := New(comp.Bytecode()).Run()
+	if err == nil || err.Error() != "stack overflow" {
+		t.Errorf("expected stack overflow, got %v", err)
+	}
+}
+
+func TestCallUnboundLocal(t *testing.T) {
+	// g has a slot but its let statement never ran, so like eval the VM does not find it
+	program, _ := ast.NewParser(token.NewLexer("fn() { if (false) { let g = 1 }; g() }()")).Parse()
+	comp := compiler.NewCompiler()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compile error %s", err)
+	}
+	err := New(comp.Bytecode()).Run()
+	if err == nil || err.Error() != "identifier not found: g" {
+		t.Errorf("expected identifier not found, got %v", err)
+	}
+}